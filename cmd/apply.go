@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/eneco/landscaper/pkg/landscaper"
@@ -10,65 +13,96 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var prefixDisable bool
-var env = &landscaper.Environment{}
+var planFile string
+var applyOutput string
+var lockTimeout time.Duration
 
 var addCmd = &cobra.Command{
 	Use:   "apply [files]...",
 	Short: "Makes the current landscape match the desired landscape",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// setup env
-		env.ComponentFiles = args
-
-		if prefixDisable {
-			env.ReleaseNamePrefix = ""
-		} else {
-			if env.ReleaseNamePrefix == "" {
-				env.ReleaseNamePrefix = fmt.Sprintf("%s-", env.Namespace) // prefix not overridden; default to '<namespace>-'
-			}
+		setupEnvironment(args)
+
+		logrus.WithFields(logrus.Fields{"namespace": env.Namespace, "releasePrefix": env.ReleaseNamePrefix, "dir": env.LandscapeDir, "dryRun": env.DryRun, "wait": env.Wait, "waitTimeout": env.WaitTimeout, "parallelism": env.Parallelism, "helmHome": env.HelmHome, "verbose": env.Verbose, "environment": env.Environment}).Info("Apply landscape desired state")
+
+		if planFile != "" && (env.DryRun || env.Loop) {
+			return errors.New("--plan cannot be combined with --dry-run or --loop")
+		}
+		if applyOutput != "table" && applyOutput != "json" {
+			return fmt.Errorf("unsupported output format `%s`; expecting table or json", applyOutput)
+		}
+		if env.RollbackAll && !env.AutoRollback {
+			return errors.New("--rollback-all requires --auto-rollback")
+		}
+		if err := validateSecretsUpdateStrategy(); err != nil {
+			return err
+		}
+		if err := landscaper.ValidateIgnoreDifferences(env.IgnoreDifferences); err != nil {
+			return err
 		}
-		env.ChartLoader = landscaper.NewLocalCharts(env.HelmHome)
 
-		v := landscaper.GetVersion()
-		logrus.WithFields(logrus.Fields{"tag": v.GitTag, "commit": v.GitCommit}).Infof("This is Landscaper %s", v.SemVer)
-		logrus.WithFields(logrus.Fields{"namespace": env.Namespace, "releasePrefix": env.ReleaseNamePrefix, "dir": env.LandscapeDir, "dryRun": env.DryRun, "wait": env.Wait, "waitTimeout": env.WaitTimeout, "helmHome": env.HelmHome, "verbose": env.Verbose, "environment": env.Environment}).Info("Apply landscape desired state")
+		kubeSecrets := newKubeSecrets()
+		secretsReader, err := newSecretsReader()
+		if err != nil {
+			return err
+		}
+		fileState, helmState, filter, err := newStateProviders(secretsReader, kubeSecrets)
+		if err != nil {
+			return err
+		}
 
-		// deprecated: populate ComponentFiles by getting *.yaml from LandscapeDir
-		if len(args) == 0 && env.LandscapeDir != "" {
-			logrus.Warnf("LandscapeDir is deprecated; please provide files as program arguments instead")
-			env.ComponentFiles = []string{env.LandscapeDir}
+		guard := landscaper.DeletionGuard{MaxDeletions: env.MaxDeletions, MaxPercentage: env.MaxDeletionPercentage, Override: env.AllowMassDeletion}
+		if isTerminal(os.Stdin) && !env.Loop {
+			guard.Confirm = confirmDeletions
 		}
 
-		kubeSecrets := landscaper.NewKubeSecretsReadWriteDeleter(env.KubeClient())
-		secretsReader := landscaper.NewEnvironmentSecretsReader()
-		if env.AzureKeyVault != "" {
-			azureSecretsReader, err := landscaper.NewAzureSecretsReader(env.AzureKeyVault)
-			if err != nil {
-				logrus.WithFields(logrus.Fields{"error": err}).Error("Failed to create an azure secrets reader")
+		var landscapeHooks *landscaper.Hooks
+		if env.HooksFile != "" {
+			if landscapeHooks, err = landscaper.ReadHooksFile(env.HooksFile); err != nil {
 				return err
 			}
-			secretsReader = azureSecretsReader
 		}
-		fileState := landscaper.NewFileStateProvider(env.ComponentFiles, secretsReader, env.ChartLoader, env.ReleaseNamePrefix, env.Namespace, env.Environment, env.ConfigurationOverrideFile)
-		helmState := landscaper.NewHelmStateProvider(env.HelmClient(), kubeSecrets, env.ReleaseNamePrefix)
-		executor := landscaper.NewExecutor(env.HelmClient(), env.ChartLoader, kubeSecrets, env.DryRun, env.Wait, int64(env.WaitTimeout/time.Second), env.DisabledStages)
+		hookRunner := landscaper.NewHookRunner(env.BatchClient())
+
+		lock := landscaper.NewLock(env.KubeClient(), env.TillerNamespace, env.ReleaseNamePrefix, env.Namespace)
+		executor := landscaper.NewExecutor(env.HelmClient(), env.ChartLoader, kubeSecrets, env.DryRun, env.Wait, int64(env.WaitTimeout/time.Second), env.DisabledStages, landscaper.WithAdoption(env.Adopt), landscaper.WithParallelism(env.Parallelism), landscaper.WithAutoRollback(env.AutoRollback, env.RollbackAll), landscaper.WithContinueOnError(env.ContinueOnError), landscaper.WithDeletionGuard(guard), landscaper.WithSecretsUpdateStrategy(env.SecretsUpdateStrategy), landscaper.WithIgnoreDifferences(env.IgnoreDifferences), landscaper.WithHooks(hookRunner, landscapeHooks), landscaper.WithReleaseTests(env.RunTests), landscaper.WithRetry(env.Retry), landscaper.WithInterrupt(lock.Err))
+
+		if planFile != "" {
+			return withLock(lock, func() error {
+				return applyPlan(executor, helmState, secretsReader, filter)
+			})
+		}
 
+		lastCommit := ""
 		for {
-			desired, err := fileState.Components()
-			if err != nil {
-				logrus.WithFields(logrus.Fields{"error": err}).Error("Loading desired state failed")
-				return err
+			if gitState != nil {
+				moved, err := gitRefMoved(&lastCommit)
+				if err != nil {
+					return err
+				}
+				if !moved {
+					logrus.Debugf("%s is still at %s. Sleeping for %s.", env.GitRef, lastCommit, env.LoopInterval)
+					time.Sleep(env.LoopInterval)
+					continue
+				}
 			}
 
-			current, err := helmState.Components()
-			if err != nil {
-				logrus.WithFields(logrus.Fields{"error": err}).Error("Loading current state failed")
-				return err
-			}
+			err := withLock(lock, func() error {
+				desired, current, err := loadStates(fileState, helmState, filter)
+				if err != nil {
+					return err
+				}
 
-			result, err := executor.Apply(desired, current)
+				result, err := executor.Apply(desired, current)
+				if werr := writeResult(result); werr != nil {
+					return werr
+				}
+				if err != nil {
+					logrus.WithFields(logrus.Fields{"error": err}).Error("Applying desired state failed")
+				}
+				return err
+			})
 			if err != nil {
-				logrus.WithFields(logrus.Fields{"error": err, "result": result}).Error("Applying desired state failed")
 				return err
 			}
 
@@ -88,44 +122,159 @@ var addCmd = &cobra.Command{
 	},
 }
 
-func init() {
-	f := addCmd.Flags()
+// gitRefMoved fetches the git repository of the desired state when looping, and tells whether --git-ref moved away
+// from lastCommit, which it updates
+func gitRefMoved(lastCommit *string) (bool, error) {
+	if env.Loop {
+		if err := gitState.Fetch(); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Error("Fetching git repository failed")
+			return false, err
+		}
+	}
+
+	commit, err := gitState.Resolve()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Error("Resolving git ref failed")
+		return false, err
+	}
+	if commit == *lastCommit {
+		return false, nil
+	}
+
+	logrus.WithFields(logrus.Fields{"ref": env.GitRef, "commit": commit}).Info("Apply landscape at git commit")
+	*lastCommit = commit
+	return true, nil
+}
+
+// withLock runs fn while holding lock, so that no other landscaper applies the landscape at the same time. A dry run
+// changes nothing, so it doesn't take the lock. When the lock is lost while fn runs, the executor stops before its next
+// phase, and the loss is returned.
+func withLock(lock *landscaper.Lock, fn func() error) error {
+	if env.DryRun {
+		return fn()
+	}
+
+	if err := lock.Acquire(lockTimeout); err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Error("Locking landscape failed")
+		return err
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Error("Releasing landscape lock failed")
+		}
+	}()
+
+	if err := fn(); err != nil {
+		return err
+	}
+	return lock.Err()
+}
+
+// applyPlan applies the changes in planFile, provided the current state didn't change since the plan was made and the
+// same components are selected
+func applyPlan(executor landscaper.Executor, helmState landscaper.StateProvider, secretsReader landscaper.SecretsReader, filter *landscaper.ComponentFilter) error {
+	f, err := os.Open(planFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	plan, err := landscaper.ReadPlan(f)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "plan": planFile}).Error("Reading plan failed")
+		return err
+	}
 
-	landscapePrefix := os.Getenv("LANDSCAPE_PREFIX")
+	if plan.Selection != filter.String() {
+		return fmt.Errorf("the plan was made with selection `%s` but is applied with selection `%s`; use the same --only, --skip and --selector", plan.Selection, filter.String())
+	}
 
-	landscapeDir := os.Getenv("LANDSCAPE_DIR")
+	current, err := helmState.Components()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Error("Loading current state failed")
+		return err
+	}
+	current = plan.SelectCurrent(current, filter)
 
-	landscapeNamespace := os.Getenv("LANDSCAPE_NAMESPACE")
-	if landscapeNamespace == "" {
-		landscapeNamespace = "default"
+	changes, err := plan.Changes(current, secretsReader)
+	if err == nil {
+		err = executor.CheckAdoptions(changes)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err, "plan": planFile}).Error("Refusing to apply plan")
+		return err
 	}
 
-	helmHome := os.ExpandEnv("$HOME/.helm")
-	tillerNamespace := os.Getenv("TILLER_NAMESPACE")
-	if tillerNamespace == "" {
-		tillerNamespace = "kube-system"
+	result, err := executor.ApplyChanges(changes, current)
+	if werr := writeResult(result); werr != nil {
+		return werr
 	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Error("Applying plan failed")
+		return err
+	}
+
+	return nil
+}
+
+// confirmDeletions asks on the terminal whether to go ahead with deleting more components than allowed
+func confirmDeletions(deletions []string, total int) bool {
+	fmt.Fprintf(os.Stderr, "About to delete %d of %d components:\n", len(deletions), total)
+	for _, name := range deletions {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+	fmt.Fprint(os.Stderr, "Continue? [y/N] ")
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// isTerminal tells whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// writeResult prints the outcome per component to stdout, in the requested output format
+func writeResult(result *landscaper.ApplyResult) error {
+	if applyOutput == "json" {
+		return result.WriteJSON(os.Stdout)
+	}
+	return result.WriteTable(os.Stdout)
+}
+
+func init() {
+	f := addCmd.Flags()
+
+	addEnvironmentFlags(f)
 
 	f.BoolVar(&env.DryRun, "dry-run", false, "simulate the applying of the landscape. useful in merge requests")
 	f.BoolVar(&env.Wait, "wait", false, "wait for all resources to be ready")
 	f.DurationVar(&env.WaitTimeout, "wait-timeout", 5*time.Minute, "interval to wait for all resources to be ready")
-	f.BoolVarP(&env.Verbose, "verbose", "v", false, "be verbose")
-	f.BoolVar(&prefixDisable, "no-prefix", false, "disable prefixing release names")
-	f.StringVar(&env.Context, "context", "", "the kube context to use. defaults to the current context")
-	f.StringVar(&env.ReleaseNamePrefix, "prefix", landscapePrefix, "prefix release names with this string instead of <namespace>; overrides LANDSCAPE_PREFIX")
-	f.StringVar(&env.LandscapeDir, "dir", landscapeDir, "(deprecated) path to a folder that contains all the landscape desired state files; overrides LANDSCAPE_DIR")
-	f.StringVar(&env.Namespace, "namespace", landscapeNamespace, "namespace to apply the landscape to; overrides LANDSCAPE_NAMESPACE")
-	f.StringVar(&env.HelmHome, "chart-dir", helmHome, "(deprecated; use --helm-home) Helm home directory")
-	f.StringVar(&env.HelmHome, "helm-home", helmHome, "Helm home directory")
-	f.StringVar(&env.TillerNamespace, "tiller-namespace", tillerNamespace, "Tiller namespace for Helm")
+	f.BoolVar(&env.Adopt, "adopt", false, "take over existing releases with the name of a component that are not controlled by landscaper, by upgrading them in place")
+	addSecretsUpdateStrategyFlag(f)
+	addIgnoreDifferencesFlag(f)
+	addSelectionFlags(f)
+	addGitFlags(f)
+	f.IntVar(&env.Parallelism, "parallelism", 1, "number of components to create, update or delete concurrently. components still wait for the components they depend on")
+	f.BoolVar(&env.ContinueOnError, "continue-on-error", false, "keep applying the other components when a component fails, skipping the ones that depend on it. all failures are reported at the end")
+	f.BoolVar(&env.AutoRollback, "auto-rollback", false, "roll back a release to its last deployed revision when upgrading it fails")
+	f.BoolVar(&env.RollbackAll, "rollback-all", false, "with --auto-rollback, roll back every component changed in the run when anything fails. created releases are deleted; deleted releases cannot be restored")
+	f.IntVar(&env.MaxDeletions, "max-deletions", 0, "refuse to delete more components than this, unless confirmed on a terminal. 0 means no limit")
+	f.Float64Var(&env.MaxDeletionPercentage, "max-deletion-percentage", 0, "refuse to delete more than this percentage of the current components, unless confirmed on a terminal. 0 means no limit")
+	f.BoolVar(&env.AllowMassDeletion, "allow-mass-deletion", false, "ignore --max-deletions and --max-deletion-percentage")
+	f.BoolVar(&env.RunTests, "run-tests", false, "run the chart tests of every created or updated component, not only of those with test: true. a failing test fails the component")
+	f.StringVar(&env.HooksFile, "hooks-file", "", "YAML file with pre and post hooks to run for every component, before the hooks of the component itself")
 	f.Var(&env.DisabledStages, "disable", "Stages to be disabled. Available stages are create/update/delete.")
 
+	f.StringVar(&applyOutput, "output", "table", "how to print the outcome per component: table or json")
+	f.StringVar(&planFile, "plan", "", "apply the changes in this plan file (see `landscaper plan`) instead of files. refuses when the current state changed since the plan was made")
+
+	f.DurationVar(&lockTimeout, "lock-timeout", 0, "how long to wait for another landscaper that is applying the same landscape. 0 fails right away")
+
 	f.BoolVar(&env.Loop, "loop", false, "keep landscape in sync forever")
 	f.DurationVar(&env.LoopInterval, "loop-interval", 5*time.Minute, "when running in a loop the interval between invocations")
 
-	f.StringVar(&env.AzureKeyVault, "azure-keyvault", "", "azure keyvault for fetching secrets. Azure credentials must be provided in the environment.")
-	f.StringVar(&env.Environment, "env", "", "environment specifier. selects value overrides by environment.")
-	f.StringVar(&env.ConfigurationOverrideFile, "config-override-file", "", "global configuration override YAML file. component specific environment overrides take precedence over this.")
-
 	rootCmd.AddCommand(addCmd)
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/eneco/landscaper/pkg/landscaper"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var errDrift = errors.New("current landscape differs from desired landscape")
+
+// exitDrift is the exit code of diff when the landscapes differ, to tell drift apart from failing to determine it
+const exitDrift = 2
+
+var (
+	diffOutput    string
+	diffManifests bool
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [files]...",
+	Short: "Shows the changes needed to make the current landscape match the desired landscape; exits with 2 when they differ and 1 on errors",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setupEnvironment(args)
+		if err := validateSecretsUpdateStrategy(); err != nil {
+			return err
+		}
+		if err := landscaper.ValidateIgnoreDifferences(env.IgnoreDifferences); err != nil {
+			return err
+		}
+		if diffOutput != "text" && diffOutput != "yaml" {
+			return fmt.Errorf("unsupported output format `%s`; expecting text or yaml", diffOutput)
+		}
+
+		logrus.WithFields(logrus.Fields{"namespace": env.Namespace, "releasePrefix": env.ReleaseNamePrefix, "dir": env.LandscapeDir, "helmHome": env.HelmHome, "verbose": env.Verbose, "environment": env.Environment}).Info("Diff landscape desired state")
+
+		kubeSecrets := newKubeSecrets()
+		secretsReader, err := newSecretsReader()
+		if err != nil {
+			return err
+		}
+		fileState, helmState, filter, err := newStateProviders(secretsReader, kubeSecrets)
+		if err != nil {
+			return err
+		}
+
+		desired, current, err := loadStates(fileState, helmState, filter)
+		if err != nil {
+			return err
+		}
+
+		// the executor is only used to determine the changes; it never applies them
+		executor := landscaper.NewExecutor(env.HelmClient(), env.ChartLoader, kubeSecrets, false, false, 0, nil, landscaper.WithAdoption(env.Adopt), landscaper.WithSecretsUpdateStrategy(env.SecretsUpdateStrategy), landscaper.WithIgnoreDifferences(env.IgnoreDifferences))
+		changes, err := executor.Diff(desired, current)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Error("Determining changes failed")
+			return err
+		}
+
+		if diffManifests {
+			diffs, err := landscaper.NewManifestDiffer(env.HelmClient(), env.ChartLoader).Diffs(changes, current)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"error": err}).Error("Rendering manifests failed")
+				return err
+			}
+			write := landscaper.WriteManifestDiffs
+			if diffOutput == "yaml" {
+				write = landscaper.WriteManifestDiffsYAML
+			}
+			if err := write(os.Stdout, diffs); err != nil {
+				return err
+			}
+		} else {
+			write := changes.WriteDiff
+			if diffOutput == "yaml" {
+				write = changes.WriteDiffYAML
+			}
+			if err := write(os.Stdout, current); err != nil {
+				return err
+			}
+		}
+
+		logrus.WithFields(logrus.Fields{"create": len(changes.Create), "update": len(changes.Update), "delete": len(changes.Delete), "blocked": len(changes.Blocked)}).Info("Determined changes")
+
+		return driftError(changes)
+	},
+}
+
+// driftError returns errDrift when there are changes, including blocked ones: a protected component that can't be
+// deleted or replaced still differs from its desired state
+func driftError(changes *landscaper.Changes) error {
+	if !changes.Empty() {
+		return errDrift
+	}
+	return nil
+}
+
+func init() {
+	f := diffCmd.Flags()
+
+	addEnvironmentFlags(f)
+
+	f.BoolVar(&env.Adopt, "adopt", false, "take over existing releases with the name of a component that are not controlled by landscaper, by upgrading them in place")
+	addSecretsUpdateStrategyFlag(f)
+	addIgnoreDifferencesFlag(f)
+	addSelectionFlags(f)
+	addGitFlags(f)
+
+	f.StringVar(&diffOutput, "output", "text", "how to print the changes per component: text or yaml")
+	f.BoolVar(&diffManifests, "manifests", false, "show the changes per Kubernetes object in the rendered manifests, instead of the changes in the values. renders the desired manifests with dry-run installs and upgrades")
+
+	rootCmd.AddCommand(diffCmd)
+}
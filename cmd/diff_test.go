@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/eneco/landscaper/pkg/landscaper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffExitCode(t *testing.T) {
+	noChanges := func() *landscaper.Changes {
+		return &landscaper.Changes{Create: landscaper.Components{}, Update: landscaper.Components{}, Delete: landscaper.Components{}, Blocked: map[string]string{}}
+	}
+
+	require.Equal(t, 0, exitCode(driftError(noChanges())))
+
+	changes := noChanges()
+	changes.Update["web"] = &landscaper.Component{Name: "web"}
+	require.Equal(t, exitDrift, exitCode(driftError(changes)))
+
+	// a protected component that would be deleted or replaced isn't touched, but still differs
+	changes = noChanges()
+	changes.Blocked["db"] = "delete"
+	require.Equal(t, exitDrift, exitCode(driftError(changes)))
+
+	require.Equal(t, 1, exitCode(errors.New("tiller unreachable")))
+}
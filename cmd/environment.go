@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/eneco/landscaper/pkg/landscaper"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+var prefixDisable bool
+var env = &landscaper.Environment{}
+var gitState landscaper.GitStateProvider // set when the desired state is read from git
+
+// addEnvironmentFlags adds the flags that describe the landscape and the cluster it lives in
+func addEnvironmentFlags(f *pflag.FlagSet) {
+	landscapePrefix := os.Getenv("LANDSCAPE_PREFIX")
+
+	landscapeDir := os.Getenv("LANDSCAPE_DIR")
+
+	landscapeNamespace := os.Getenv("LANDSCAPE_NAMESPACE")
+	if landscapeNamespace == "" {
+		landscapeNamespace = "default"
+	}
+
+	helmHome := os.ExpandEnv("$HOME/.helm")
+	tillerNamespace := os.Getenv("TILLER_NAMESPACE")
+	if tillerNamespace == "" {
+		tillerNamespace = "kube-system"
+	}
+
+	f.BoolVarP(&env.Verbose, "verbose", "v", false, "be verbose")
+	f.BoolVar(&prefixDisable, "no-prefix", false, "disable prefixing release names")
+	f.StringVar(&env.Context, "context", "", "the kube context to use. defaults to the current context")
+	f.StringVar(&env.ReleaseNamePrefix, "prefix", landscapePrefix, "prefix release names with this string instead of <namespace>; overrides LANDSCAPE_PREFIX")
+	f.StringVar(&env.LandscapeDir, "dir", landscapeDir, "(deprecated) path to a folder that contains all the landscape desired state files; overrides LANDSCAPE_DIR")
+	f.StringVar(&env.Namespace, "namespace", landscapeNamespace, "namespace to apply the landscape to; overrides LANDSCAPE_NAMESPACE")
+	f.StringVar(&env.HelmHome, "chart-dir", helmHome, "(deprecated; use --helm-home) Helm home directory")
+	f.StringVar(&env.HelmHome, "helm-home", helmHome, "Helm home directory")
+	f.StringVar(&env.TillerNamespace, "tiller-namespace", tillerNamespace, "Tiller namespace for Helm")
+
+	f.StringVar(&env.AzureKeyVault, "azure-keyvault", "", "azure keyvault for fetching secrets. Azure credentials must be provided in the environment.")
+	f.StringVar(&env.Environment, "env", "", "environment specifier. selects value overrides by environment.")
+	f.StringVar(&env.ConfigurationOverrideFile, "config-override-file", "", "global configuration override YAML file. component specific environment overrides take precedence over this.")
+
+	f.StringSliceVar(&env.RedactKeys, "redact-keys", landscaper.DefaultRedactKeys, "patterns (regular expressions, case-insensitive) of the keys whose values are redacted in logs and diffs; secret values are always redacted")
+
+	f.IntVar(&env.Retry.Attempts, "retry-attempts", 3, "number of attempts of calls to Tiller and Kubernetes that fail with a transient error, such as an unavailable Tiller. 1 disables retrying")
+	f.DurationVar(&env.Retry.BaseDelay, "retry-base-delay", time.Second, "delay before the first retry; it doubles with every retry")
+	f.DurationVar(&env.Retry.MaxDelay, "retry-max-delay", 30*time.Second, "maximum delay between retries")
+}
+
+// addSecretsUpdateStrategyFlag adds the flag that selects how pods get to use changed secret values
+func addSecretsUpdateStrategyFlag(f *pflag.FlagSet) {
+	f.StringVar(&env.SecretsUpdateStrategy, "secrets-update-strategy", landscaper.SecretsUpdateRestart, "how to handle components of which only secret values changed: restart (update the secretsChecksum value, for a rolling update of the pods that use it) or recreate (delete + create the release)")
+}
+
+// validateSecretsUpdateStrategy makes sure a supported secrets update strategy was chosen
+func validateSecretsUpdateStrategy() error {
+	if env.SecretsUpdateStrategy != landscaper.SecretsUpdateRestart && env.SecretsUpdateStrategy != landscaper.SecretsUpdateRecreate {
+		return fmt.Errorf("unsupported secrets update strategy `%s`; expecting %s or %s", env.SecretsUpdateStrategy, landscaper.SecretsUpdateRestart, landscaper.SecretsUpdateRecreate)
+	}
+	return nil
+}
+
+// addIgnoreDifferencesFlag adds the flag with the value paths whose differences are ignored for every component
+func addIgnoreDifferencesFlag(f *pflag.FlagSet) {
+	f.StringSliceVar(&env.IgnoreDifferences, "ignore-differences", nil, "value paths whose differences don't cause an update, for every component, e.g. image.tag; a path between slashes is a regular expression, e.g. /replicas$/")
+}
+
+// addGitFlags adds the flags that read the desired state from a git repository instead of the file system
+func addGitFlags(f *pflag.FlagSet) {
+	f.StringVar(&env.GitRepo, "git-repo", "", "local git repository to read the component files from, at --git-ref, without checking it out. files are paths in the repository; without files, the *.yaml in its root are read")
+	f.StringVar(&env.GitRef, "git-ref", "HEAD", "commit, branch or tag in --git-repo to read the component files at. with --loop, use a remote-tracking branch such as origin/master, which moves when fetched")
+}
+
+// addSelectionFlags adds the flags that select the components to handle
+func addSelectionFlags(f *pflag.FlagSet) {
+	f.StringSliceVar(&env.Only, "only", nil, "only handle the components whose names match one of these glob patterns; other components are neither created, updated nor deleted")
+	f.StringSliceVar(&env.Skip, "skip", nil, "leave the components whose names match one of these glob patterns alone")
+	f.StringVar(&env.Selector, "selector", "", "only handle the components whose labels match this label selector, e.g. team=payments")
+}
+
+// setupEnvironment completes env with the provided component files and derived settings
+func setupEnvironment(args []string) {
+	env.ComponentFiles = args
+
+	if prefixDisable {
+		env.ReleaseNamePrefix = ""
+	} else {
+		if env.ReleaseNamePrefix == "" {
+			env.ReleaseNamePrefix = fmt.Sprintf("%s-", env.Namespace) // prefix not overridden; default to '<namespace>-'
+		}
+	}
+	env.ChartLoader = landscaper.NewLocalCharts(env.HelmHome)
+
+	v := landscaper.GetVersion()
+	logrus.WithFields(logrus.Fields{"tag": v.GitTag, "commit": v.GitCommit}).Infof("This is Landscaper %s", v.SemVer)
+
+	// deprecated: populate ComponentFiles by getting *.yaml from LandscapeDir
+	if len(args) == 0 && env.LandscapeDir != "" {
+		logrus.Warnf("LandscapeDir is deprecated; please provide files as program arguments instead")
+		env.ComponentFiles = []string{env.LandscapeDir}
+	}
+}
+
+// newKubeSecrets creates the reader and writer of the secrets in Kubernetes
+func newKubeSecrets() landscaper.SecretsReadWriteDeleter {
+	return landscaper.NewRetryingSecrets(landscaper.NewKubeSecretsReadWriteDeleter(env.KubeClient()), env.Retry)
+}
+
+// newSecretsReader creates the reader for the secret values of desired components
+func newSecretsReader() (landscaper.SecretsReader, error) {
+	if env.AzureKeyVault != "" {
+		azureSecretsReader, err := landscaper.NewAzureSecretsReader(env.AzureKeyVault)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Error("Failed to create an azure secrets reader")
+			return nil, err
+		}
+		return azureSecretsReader, nil
+	}
+	return landscaper.NewEnvironmentSecretsReader(), nil
+}
+
+// newStateProviders creates the providers of the desired state (files, or git with --git-repo) and the current state
+// (Helm), and the filter of the components selected by --only, --skip and --selector
+func newStateProviders(secretsReader landscaper.SecretsReader, kubeSecrets landscaper.SecretsReader) (landscaper.StateProvider, landscaper.StateProvider, *landscaper.ComponentFilter, error) {
+	filter, err := landscaper.NewComponentFilter(env.Only, env.Skip, env.Selector, env.ReleaseNamePrefix)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	fileState := landscaper.NewFileStateProvider(env.ComponentFiles, secretsReader, env.ChartLoader, env.ReleaseNamePrefix, env.Namespace, env.Environment, env.ConfigurationOverrideFile)
+	if env.GitRepo != "" {
+		gitState = landscaper.NewGitStateProvider(env.GitRepo, env.GitRef, env.ComponentFiles, secretsReader, env.ChartLoader, env.ReleaseNamePrefix, env.Namespace, env.Environment, env.ConfigurationOverrideFile)
+		fileState = gitState
+	}
+	helmState := landscaper.NewHelmStateProvider(env.HelmClient(), kubeSecrets, env.ReleaseNamePrefix)
+	return fileState, helmState, filter, nil
+}
+
+// loadStates loads the desired and the current state, limited to the components filter selects. Both states are
+// limited, so that the components outside of the selection are left alone rather than deleted.
+func loadStates(fileState, helmState landscaper.StateProvider, filter *landscaper.ComponentFilter) (landscaper.Components, landscaper.Components, error) {
+	desired, err := fileState.Components()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Error("Loading desired state failed")
+		return nil, nil, err
+	}
+
+	current, err := helmState.Components()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"error": err}).Error("Loading current state failed")
+		return nil, nil, err
+	}
+
+	desired, current = filter.SelectStates(desired, current)
+	return desired, current, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/eneco/landscaper/pkg/landscaper"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var exportOutputDir string
+var exportIncludeUnmanaged bool
+var exportChartRepository string
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Writes a landscape file for every release in the current landscape, holding the values that differ from the chart defaults",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setupEnvironment(args)
+
+		logrus.WithFields(logrus.Fields{"namespace": env.Namespace, "releasePrefix": env.ReleaseNamePrefix, "outputDir": exportOutputDir, "includeUnmanaged": exportIncludeUnmanaged}).Info("Export landscape current state")
+
+		kubeSecrets := newKubeSecrets()
+		exporter := landscaper.NewHelmExporter(env.HelmClient(), kubeSecrets, env.ReleaseNamePrefix, env.Namespace, exportIncludeUnmanaged, exportChartRepository)
+
+		files, err := exporter.Export()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Error("Exporting current state failed")
+			return err
+		}
+
+		if err := os.MkdirAll(exportOutputDir, 0755); err != nil {
+			return err
+		}
+
+		for name, content := range files {
+			path := filepath.Join(exportOutputDir, name)
+			if err := ioutil.WriteFile(path, content, 0644); err != nil {
+				return err
+			}
+			logrus.WithFields(logrus.Fields{"file": path}).Info("Wrote component")
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	f := exportCmd.Flags()
+
+	addEnvironmentFlags(f)
+
+	f.StringVarP(&exportOutputDir, "output-dir", "o", ".", "directory to write the landscape files to")
+	f.BoolVar(&exportIncludeUnmanaged, "include-unmanaged", false, "also export releases that are not controlled by landscaper")
+	f.StringVar(&exportChartRepository, "chart-repo", "stable", "chart repository to attribute the charts of releases not controlled by landscaper to")
+
+	rootCmd.AddCommand(exportCmd)
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/eneco/landscaper/pkg/landscaper"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	prefixed "github.com/x-cray/logrus-prefixed-formatter"
@@ -21,6 +22,9 @@ var rootCmd = &cobra.Command{
 		if env.Verbose {
 			logrus.SetLevel(logrus.DebugLevel)
 		}
+		if env.RedactKeys != nil {
+			return landscaper.SetRedactKeys(env.RedactKeys)
+		}
 		return nil
 	},
 	SilenceUsage: true,
@@ -34,10 +38,23 @@ func init() {
 		FullTimestamp:   true,
 	}
 	logrus.SetFormatter(p)
+	logrus.AddHook(landscaper.RedactionHook())
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the code to exit with after a command returned err
+func exitCode(err error) int {
+	switch err {
+	case nil:
+		return 0
+	case errDrift:
+		return exitDrift
+	default:
+		return 1
 	}
 }
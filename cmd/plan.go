@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/eneco/landscaper/pkg/landscaper"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var planOutputFile string
+
+var planCmd = &cobra.Command{
+	Use:   "plan [files]...",
+	Short: "Writes the changes needed to make the current landscape match the desired landscape to a plan file, to be applied with `apply --plan`",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if planOutputFile == "" {
+			return errors.New("no plan file provided; use -o")
+		}
+
+		setupEnvironment(args)
+		if err := validateSecretsUpdateStrategy(); err != nil {
+			return err
+		}
+		if err := landscaper.ValidateIgnoreDifferences(env.IgnoreDifferences); err != nil {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{"namespace": env.Namespace, "releasePrefix": env.ReleaseNamePrefix, "dir": env.LandscapeDir, "helmHome": env.HelmHome, "verbose": env.Verbose, "environment": env.Environment, "plan": planOutputFile}).Info("Plan landscape desired state")
+
+		kubeSecrets := newKubeSecrets()
+		secretsReader, err := newSecretsReader()
+		if err != nil {
+			return err
+		}
+		fileState, helmState, filter, err := newStateProviders(secretsReader, kubeSecrets)
+		if err != nil {
+			return err
+		}
+
+		desired, current, err := loadStates(fileState, helmState, filter)
+		if err != nil {
+			return err
+		}
+
+		// the executor is only used to determine the changes; it never applies them
+		executor := landscaper.NewExecutor(env.HelmClient(), env.ChartLoader, kubeSecrets, false, false, 0, nil, landscaper.WithAdoption(env.Adopt), landscaper.WithSecretsUpdateStrategy(env.SecretsUpdateStrategy), landscaper.WithIgnoreDifferences(env.IgnoreDifferences))
+		changes, err := executor.Diff(desired, current)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Error("Determining changes failed")
+			return err
+		}
+
+		if err := changes.WriteDiff(os.Stdout, current); err != nil {
+			return err
+		}
+
+		plan, err := landscaper.NewPlan(changes, current)
+		if err != nil {
+			return err
+		}
+		plan.SetSelection(filter, current)
+
+		f, err := os.Create(planOutputFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := plan.Write(f); err != nil {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{"create": len(plan.Create), "update": len(plan.Update), "delete": len(plan.Delete), "plan": planOutputFile}).Info("Wrote plan")
+		return nil
+	},
+}
+
+func init() {
+	f := planCmd.Flags()
+
+	addEnvironmentFlags(f)
+
+	f.BoolVar(&env.Adopt, "adopt", false, "take over existing releases with the name of a component that are not controlled by landscaper, by upgrading them in place")
+	addSecretsUpdateStrategyFlag(f)
+	addIgnoreDifferencesFlag(f)
+	addSelectionFlags(f)
+	addGitFlags(f)
+	f.StringVarP(&planOutputFile, "output", "o", "", "file to write the plan to")
+
+	rootCmd.AddCommand(planCmd)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/eneco/landscaper/pkg/landscaper"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Breaks the lock on the landscape, e.g. when a landscaper that was applying it crashed",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setupEnvironment(args)
+
+		logrus.WithFields(logrus.Fields{"namespace": env.Namespace, "releasePrefix": env.ReleaseNamePrefix, "tillerNamespace": env.TillerNamespace}).Info("Unlock landscape")
+
+		holder, err := landscaper.NewLock(env.KubeClient(), env.TillerNamespace, env.ReleaseNamePrefix, env.Namespace).Break()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err}).Error("Unlocking landscape failed")
+			return err
+		}
+
+		if holder == "" {
+			logrus.Info("Landscape wasn't locked")
+			return nil
+		}
+		logrus.WithFields(logrus.Fields{"holder": holder}).Warn("Broke landscape lock")
+		return nil
+	},
+}
+
+func init() {
+	f := unlockCmd.Flags()
+
+	addEnvironmentFlags(f)
+
+	rootCmd.AddCommand(unlockCmd)
+}
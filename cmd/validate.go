@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/eneco/landscaper/pkg/landscaper"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [files]...",
+	Short: "Checks landscape files offline, without contacting Tiller or Kubernetes, and reports every problem found",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setupEnvironment(args)
+
+		logrus.WithFields(logrus.Fields{"namespace": env.Namespace, "releasePrefix": env.ReleaseNamePrefix, "dir": env.LandscapeDir, "helmHome": env.HelmHome, "environment": env.Environment}).Info("Validate landscape desired state")
+
+		validator := landscaper.NewFileValidator(env.ComponentFiles, env.ChartLoader, env.ReleaseNamePrefix, env.Namespace, env.Environment, env.ConfigurationOverrideFile)
+		errs := validator.Validate()
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+
+		if len(errs) > 0 {
+			return fmt.Errorf("found %d problem(s) in the landscape files", len(errs))
+		}
+
+		logrus.Info("Landscape files are valid")
+		return nil
+	},
+}
+
+func init() {
+	addEnvironmentFlags(validateCmd.Flags())
+
+	rootCmd.AddCommand(validateCmd)
+}
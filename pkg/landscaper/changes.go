@@ -0,0 +1,97 @@
+package landscaper
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ghodss/yaml"
+)
+
+// Changes holds the components to create, update and delete to get from the current to the desired state
+type Changes struct {
+	Create Components
+	Update Components
+	Delete Components
+	Forced map[string]bool // components that are deleted and created instead of updated
+	Adopt  map[string]bool // components that are updated to take over a release not controlled by landscaper
+
+	ForcedReasons map[string]string // why the Forced components are deleted and created
+	Blocked       map[string]string // protected components that are left alone instead of deleted ("delete") or replaced ("replace")
+}
+
+// Empty tells whether the current state already matches the desired state. Blocked changes count too: although they
+// aren't applied, the states differ.
+func (c *Changes) Empty() bool {
+	return len(c.Create) == 0 && len(c.Update) == 0 && len(c.Delete) == 0 && len(c.Blocked) == 0
+}
+
+// Diffs returns the differences per changed component, in the order in which they would be applied. Protected
+// components whose delete or replace is blocked come first.
+func (c *Changes) Diffs(current Components) []*ComponentDiff {
+	diffs := []*ComponentDiff{}
+
+	for _, name := range sortedNames(c.Blocked) {
+		d := &ComponentDiff{Component: name, Action: c.Blocked[name], Blocked: true}
+		if d.Action == "replace" {
+			d.Reason = c.ForcedReasons[name]
+		}
+		diffs = append(diffs, d)
+	}
+
+	for _, name := range c.Delete.names() {
+		if c.Forced[name] {
+			continue // shown as a replacement below
+		}
+		d := diffComponents(c.Delete[name], nil)
+		d.Action = "delete"
+		diffs = append(diffs, d)
+	}
+
+	for _, name := range c.Update.names() {
+		d := diffComponents(current[name], c.Update[name])
+		d.Action = "update"
+		if c.Adopt[name] {
+			d.Action = "adopt"
+		}
+		diffs = append(diffs, d)
+	}
+
+	for _, name := range c.Create.names() {
+		if c.Forced[name] {
+			d := diffComponents(current[name], c.Create[name])
+			d.Action, d.Reason = "replace", c.ForcedReasons[name]
+			diffs = append(diffs, d)
+			continue
+		}
+		d := diffComponents(nil, c.Create[name])
+		d.Action = "create"
+		diffs = append(diffs, d)
+	}
+
+	return diffs
+}
+
+// WriteDiff writes the differences of each changed component to w as text, in the order in which they would be applied
+func (c *Changes) WriteDiff(w io.Writer, current Components) error {
+	for _, d := range c.Diffs(current) {
+		if _, err := fmt.Fprintln(w, d.Title()); err != nil {
+			return err
+		}
+		for _, line := range d.Lines() {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteDiffYAML writes the differences of each changed component to w as YAML
+func (c *Changes) WriteDiffYAML(w io.Writer, current Components) error {
+	out, err := yaml.Marshal(c.Diffs(current))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
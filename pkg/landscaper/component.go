@@ -1,27 +1,48 @@
 package landscaper
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
 	"gopkg.in/validator.v2"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 // Component contains information about the release, configuration and secrets of a component
 type Component struct {
-	Name          string         `json:"name" validate:"nonzero,max=51"`
-	Namespace     string         `json:"namespace"`
-	Release       *Release       `json:"release" validate:"nonzero"`
-	Configuration Configuration  `json:"configuration"`
-	Environments  Configurations `json:"environments"`
-	SecretsRaw    interface{}    `json:"secrets"`
-	SecretNames   SecretNames    `json:"-"`
-	SecretValues  SecretValues   `json:"-"`
+	Name          string            `json:"name" validate:"nonzero,max=53"` // Helm's maximum release name length
+	Namespace     string            `json:"namespace"`
+	Release       *Release          `json:"release" validate:"nonzero"`
+	Configuration Configuration     `json:"configuration"`
+	Environments  Configurations    `json:"environments"`
+	SecretsRaw    interface{}       `json:"secrets"`
+	SecretNames   SecretNames       `json:"-"`
+	SecretValues  SecretValues      `json:"-"`
+	SecretSources map[string]string `json:"-"`                   // per secret key, where its value was read from
+	DependsOn     []string          `json:"dependsOn,omitempty"` // names of the components that must be in place before this one
+	Protect       bool              `json:"protect,omitempty"`   // never delete the release, nor replace it by a delete + create
+	Labels        map[string]string `json:"labels,omitempty"`    // to select components with --selector
+	Hooks         *Hooks            `json:"hooks,omitempty"`     // run before and after creating, updating or deleting the release
+	Test          bool              `json:"test,omitempty"`      // run the chart's tests after creating or updating the release
+	Revision      int32             `json:"-"`                   // revision of the release, if it exists
+	ChartDefaults Configuration     `json:"-"`                   // the default values of the chart, to leave them out of diffs
+
+	// IgnoreDifferences are the value paths whose differences don't cause an update, such as image tags that are set
+	// outside of the component files; see ignore.go
+	IgnoreDifferences []string `json:"ignoreDifferences,omitempty"`
 }
 
 // Components is a collection of uniquely named Component objects
 type Components map[string]*Component
 
+// secretsChecksumKey is the configuration key that holds a checksum of the secret values. Charts can put it in an
+// annotation of their pod templates, so that a change of the secret values results in a rolling update.
+const secretsChecksumKey = "secretsChecksum"
+
 // NewComponent creates a Component and adds Name to the configuration
 func NewComponent(name string, namespace string, release *Release, cfg Configuration, envs Configurations, secretNames SecretNames) *Component {
 	cmp := &Component{
@@ -52,13 +73,80 @@ func NewComponent(name string, namespace string, release *Release, cfg Configura
 	}
 	m.ReleaseVersion = cmp.Release.Version
 	cmp.Configuration.SetMetadata(m)
+	cmp.DependsOn = m.DependsOn
+	cmp.Protect = m.Protect
+	cmp.Labels = m.Labels
 
 	return cmp
 }
 
+// setSecretsChecksum stores a checksum of the secret values in the configuration, if there are any
+func (c *Component) setSecretsChecksum() {
+	if len(c.SecretValues) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(c.SecretValues))
+	for key := range c.SecretValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write(c.SecretValues[key])
+		h.Write([]byte{0})
+	}
+	c.Configuration[secretsChecksumKey] = hex.EncodeToString(h.Sum(nil))
+}
+
+// setSecretSources records where each secret value of c was read from, if secrets can tell
+func (c *Component) setSecretSources(secrets SecretsReader) {
+	source, ok := secrets.(SecretsSource)
+	if !ok {
+		return
+	}
+
+	c.SecretSources = map[string]string{}
+	for key := range c.SecretValues {
+		reference := key
+		if ref, ok := c.SecretNames[key]; ok {
+			reference = ref
+		}
+		if s := source.Source(c.Name, c.Namespace, reference); s != "" {
+			c.SecretSources[key] = s
+		}
+	}
+}
+
 // Validate the component on required fields and correct values
 func (c *Component) Validate() error {
-	return validator.Validate(c)
+	if err := validator.Validate(c); err != nil {
+		return err
+	}
+
+	// release names end up in names of Kubernetes objects
+	if errs := validation.IsDNS1123Label(c.Name); len(errs) > 0 {
+		return fmt.Errorf("release name `%s` is invalid: %s", c.Name, strings.Join(errs, "; "))
+	}
+
+	if err := c.Hooks.Validate(); err != nil {
+		return err
+	}
+
+	if err := ValidateIgnoreDifferences(c.IgnoreDifferences); err != nil {
+		return err
+	}
+
+	for k, v := range c.Labels {
+		if errs := append(validation.IsQualifiedName(k), validation.IsValidLabelValue(v)...); len(errs) > 0 {
+			return fmt.Errorf("label `%s: %s` is invalid: %s", k, v, strings.Join(errs, "; "))
+		}
+	}
+
+	return nil
 }
 
 // Equals checks if this component's values are equal to another
@@ -68,11 +156,188 @@ func (c *Component) Equals(other *Component) bool {
 
 	// Don't compare the SecretNames because we don't rebuild them from the cluster.
 	otherCopy.SecretNames = c.SecretNames
+	// Nor the revisions; desired components don't have one.
+	otherCopy.Revision = c.Revision
+	// Nor the hooks; only those for deletes are stored in the release, and the configuration covers them.
+	otherCopy.Hooks = c.Hooks
+	// Nor whether to test it.
+	otherCopy.Test = c.Test
+	// Nor where the secret values came from.
+	otherCopy.SecretSources = c.SecretSources
+	// Nor the chart defaults; the configuration already includes them.
+	otherCopy.ChartDefaults = c.ChartDefaults
+	// Nor the values whose differences are ignored.
+	otherCopy.IgnoreDifferences = c.IgnoreDifferences
+	// Nor the commit the desired state was read from.
+	cCopy := *c
+	cCopy.Configuration = withoutIgnored(withoutCommit(c.Configuration), c.IgnoreDifferences)
+	otherCopy.Configuration = withoutIgnored(withoutCommit(other.Configuration), c.IgnoreDifferences)
+
+	return reflect.DeepEqual(&cCopy, otherCopy)
+}
+
+// names returns the sorted names of the components
+func (cs Components) names() []string {
+	names := make([]string, 0, len(cs))
+	for name := range cs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// inDependencyOrder returns the components of cs ordered such that every component comes after the components it depends on.
+// Dependencies are resolved through all, so that indirect dependencies via components outside of cs are honoured as well.
+func inDependencyOrder(cs, all Components) ([]*Component, error) {
+	graph := Components{}
+	for name, cmp := range all {
+		graph[name] = cmp
+	}
+	for name, cmp := range cs {
+		graph[name] = cmp
+	}
+
+	order, err := graph.dependencyOrder()
+	if err != nil {
+		return nil, err
+	}
 
-	return reflect.DeepEqual(c, otherCopy)
+	ordered := []*Component{}
+	for _, name := range order {
+		if cmp, ok := cs[name]; ok {
+			ordered = append(ordered, cmp)
+		}
+	}
+	return ordered, nil
+}
+
+// dependenciesWithin returns for every component in cs the components in cs it depends on, either directly or through
+// components in all
+func dependenciesWithin(cs, all Components) map[string][]string {
+	graph := Components{}
+	for name, cmp := range all {
+		graph[name] = cmp
+	}
+	for name, cmp := range cs {
+		graph[name] = cmp
+	}
+
+	deps := map[string][]string{}
+	for name := range cs {
+		for _, dep := range graph.allDependencies(name) {
+			if _, ok := cs[dep]; ok {
+				deps[name] = append(deps[name], dep)
+			}
+		}
+	}
+	return deps
+}
+
+// allDependencies returns the components in cs that the named component depends on, directly or indirectly
+func (cs Components) allDependencies(name string) []string {
+	deps := []string{}
+	seen := map[string]bool{name: true}
+
+	var visit func(n string)
+	visit = func(n string) {
+		cmp, ok := cs[n]
+		if !ok {
+			return
+		}
+		for _, dep := range cmp.DependsOn {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			if _, ok := cs[dep]; ok {
+				deps = append(deps, dep)
+				visit(dep)
+			}
+		}
+	}
+	visit(name)
+
+	return deps
+}
+
+// invertDependencies turns a map of components to the components they depend on into a map of components to their dependants
+func invertDependencies(deps map[string][]string) map[string][]string {
+	dependants := map[string][]string{}
+	for name, ds := range deps {
+		for _, dep := range ds {
+			dependants[dep] = append(dependants[dep], name)
+		}
+	}
+	return dependants
 }
 
-// validateComponents validates the individual components as well as duplicate names in the total collection
+// dependencyOrder returns the names of the components such that every component comes after the components it depends on.
+// Dependencies on unknown components are ignored. Independent components are ordered by name.
+func (cs Components) dependencyOrder() ([]string, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	order := []string{}
+	state := map[string]int{}
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					path = path[i:]
+					break
+				}
+			}
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		deps := append([]string{}, cs[name].DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if _, ok := cs[dep]; !ok {
+				continue
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range cs.names() {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// validateDependencies makes sure components only depend on components in cs, and that there are no dependency cycles
+func validateDependencies(cs Components) error {
+	for _, name := range cs.names() {
+		for _, dep := range cs[name].DependsOn {
+			if _, ok := cs[dep]; !ok {
+				return fmt.Errorf("component `%s` depends on unknown component `%s`", name, dep)
+			}
+		}
+	}
+
+	_, err := cs.dependencyOrder()
+	return err
+}
+
+// validateComponents validates the individual components as well as the dependencies between them
 func validateComponents(cs Components) error {
 	// are the individual components okay?
 	for _, c := range cs {
@@ -81,7 +346,7 @@ func validateComponents(cs Components) error {
 		}
 	}
 
-	return nil
+	return validateDependencies(cs)
 }
 
 // FullChartRef provides a chart references like "myRepo/chartName"
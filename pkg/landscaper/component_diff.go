@@ -0,0 +1,356 @@
+package landscaper
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// internalKeys are the configuration keys that landscaper adds to the values of a release for its own use
+var internalKeys = []string{metadataKey, "Name", "secretsRef", secretsChecksumKey}
+
+// the kinds of ValueChange
+const (
+	ValueAdded   = "added"
+	ValueRemoved = "removed"
+	ValueChanged = "changed"
+)
+
+// ValueChange is a difference between the current and the desired state of a component at a single path, such as
+// image.tag. Added paths have no current value; removed paths no desired one.
+type ValueChange struct {
+	Path    string      `json:"path"`
+	Kind    string      `json:"kind"`
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+// SecretChange is a secret key that is added, removed or changed. It never holds the secret's value; Source tells where
+// the desired value is read from, or for a removed key, where the current value is kept.
+type SecretChange struct {
+	Key    string `json:"key"`
+	Kind   string `json:"kind"`
+	Source string `json:"source,omitempty"`
+}
+
+// ComponentDiff holds the differences between the current and the desired state of a component. The Attributes are
+// those of the component itself, such as its chart and namespace; the Values are those of its configuration, without
+// the keys landscaper uses internally, the keys that have the default value of the chart and the ignored keys. Sensitive
+// and secret values are redacted.
+type ComponentDiff struct {
+	Component  string          `json:"component"`
+	Action     string          `json:"action"`            // create, update, adopt, replace or delete
+	Reason     string          `json:"reason,omitempty"`  // why a component is replaced
+	Blocked    bool            `json:"blocked,omitempty"` // the action is not performed because the component is protected
+	Attributes []*ValueChange  `json:"attributes,omitempty"`
+	Secrets    []*SecretChange `json:"secrets,omitempty"`
+	Values     []*ValueChange  `json:"values,omitempty"`
+}
+
+// secretsSalt is mixed into the hashes that secret values are compared by. It is generated for every run, so that the
+// hashes can't be matched against those of known values.
+var secretsSalt = newSecretsSalt()
+
+func newSecretsSalt() []byte {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		panic(fmt.Sprintf("failed to generate salt for secret hashes: %s", err))
+	}
+	return salt
+}
+
+// diffComponents returns the differences between current and desired. Either can be nil, for a create or a delete.
+func diffComponents(current, desired *Component) *ComponentDiff {
+	d := &ComponentDiff{}
+	var cAttrs, dAttrs, cValues, dValues, cDefaults, dDefaults map[string]interface{}
+	if current != nil {
+		d.Component = current.Name
+		cAttrs, cValues = componentAttributes(current), flattenValues(withoutInternalKeys(current.Configuration))
+		cDefaults = flattenValues(current.ChartDefaults)
+	}
+	if desired != nil {
+		d.Component = desired.Name
+		dAttrs, dValues = componentAttributes(desired), flattenValues(withoutInternalKeys(desired.Configuration))
+		dDefaults = flattenValues(desired.ChartDefaults)
+	}
+
+	d.Attributes = diffLeaves(cAttrs, dAttrs, nil)
+	d.Secrets = diffSecrets(current, desired)
+
+	// a path is left out when it has the chart's default value, or is absent, on both sides, or when its differences
+	// are ignored. Ignore rules only apply to updates; a create or a delete shows every value that differs from the
+	// chart defaults.
+	var ignore []string
+	if current != nil && desired != nil {
+		ignore = desired.IgnoreDifferences
+	}
+	hide := func(path string) bool {
+		return (atDefault(path, cValues, cDefaults) && atDefault(path, dValues, dDefaults)) || ignoresPath(ignore, path)
+	}
+	d.Values = diffLeaves(cValues, dValues, hide)
+
+	for i, vc := range d.Attributes {
+		d.Attributes[i] = redaction.valueChange(vc)
+	}
+	for i, vc := range d.Values {
+		d.Values[i] = redaction.valueChange(vc)
+	}
+	return d
+}
+
+// componentAttributes returns the attributes of cmp that are compared, by their path
+func componentAttributes(cmp *Component) map[string]interface{} {
+	chartRef, err := cmp.FullChartRef()
+	if err != nil {
+		chartRef = cmp.Release.Chart
+	}
+	attrs := map[string]interface{}{
+		"namespace":       cmp.Namespace,
+		"release.chart":   chartRef,
+		"release.version": cmp.Release.Version,
+	}
+	if len(cmp.DependsOn) > 0 {
+		attrs["dependsOn"] = strings.Join(cmp.DependsOn, ", ")
+	}
+	if cmp.Protect {
+		attrs["protect"] = true
+	}
+	for k, v := range cmp.Labels {
+		attrs["labels."+k] = v
+	}
+	return attrs
+}
+
+// diffSecrets returns the secret keys that differ between current and desired, sorted by key. Either can be nil. The
+// values are compared by their salted hashes, which are never shown.
+func diffSecrets(current, desired *Component) []*SecretChange {
+	var cHashes, dHashes map[string]string
+	var cSources, dSources map[string]string
+	if current != nil {
+		cHashes, cSources = secretHashes(current.SecretValues), current.SecretSources
+	}
+	if desired != nil {
+		dHashes, dSources = secretHashes(desired.SecretValues), desired.SecretSources
+	}
+
+	keys := []string{}
+	for key := range cHashes {
+		keys = append(keys, key)
+	}
+	for key := range dHashes {
+		if _, ok := cHashes[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []*SecretChange{}
+	for _, key := range keys {
+		c, inCurrent := cHashes[key]
+		d, inDesired := dHashes[key]
+		switch {
+		case !inCurrent:
+			changes = append(changes, &SecretChange{Key: key, Kind: ValueAdded, Source: dSources[key]})
+		case !inDesired:
+			changes = append(changes, &SecretChange{Key: key, Kind: ValueRemoved, Source: cSources[key]})
+		case c != d:
+			changes = append(changes, &SecretChange{Key: key, Kind: ValueChanged, Source: dSources[key]})
+		}
+	}
+	return changes
+}
+
+// secretHashes returns the salted hash of each secret value, by key
+func secretHashes(values SecretValues) map[string]string {
+	hashes := map[string]string{}
+	for key, value := range values {
+		h := sha256.New()
+		h.Write(secretsSalt)
+		h.Write(value)
+		hashes[key] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return hashes
+}
+
+// secretKeys returns the keys of changes, e.g. to say which secrets caused a component to be replaced
+func secretKeys(changes []*SecretChange) string {
+	keys := []string{}
+	for _, sc := range changes {
+		keys = append(keys, sc.Key)
+	}
+	return strings.Join(keys, ", ")
+}
+
+// diffLeaves compares two sets of leaves by path and returns their differences, sorted by path. The paths for which
+// hide returns true are left out.
+func diffLeaves(current, desired map[string]interface{}, hide func(path string) bool) []*ValueChange {
+	paths := []string{}
+	for path := range current {
+		paths = append(paths, path)
+	}
+	for path := range desired {
+		if _, ok := current[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	changes := []*ValueChange{}
+	for _, path := range paths {
+		if hide != nil && hide(path) {
+			continue
+		}
+		c, inCurrent := current[path]
+		d, inDesired := desired[path]
+		switch {
+		case !inCurrent:
+			changes = append(changes, &ValueChange{Path: path, Kind: ValueAdded, Desired: d})
+		case !inDesired:
+			changes = append(changes, &ValueChange{Path: path, Kind: ValueRemoved, Current: c})
+		case !reflect.DeepEqual(c, d):
+			changes = append(changes, &ValueChange{Path: path, Kind: ValueChanged, Current: c, Desired: d})
+		}
+	}
+	return changes
+}
+
+// atDefault tells whether path is absent from values or has the same value as in defaults
+func atDefault(path string, values, defaults map[string]interface{}) bool {
+	v, ok := values[path]
+	if !ok {
+		return true
+	}
+	dv, ok := defaults[path]
+	return ok && reflect.DeepEqual(v, dv)
+}
+
+// withoutInternalKeys returns a shallow copy of cfg without the keys that landscaper uses internally
+func withoutInternalKeys(cfg Configuration) Configuration {
+	for _, key := range internalKeys {
+		cfg = withoutKey(cfg, key)
+	}
+	return cfg
+}
+
+// flattenValues returns the leaves of the configuration tree cfg by their path, e.g. image.tag or args[0].
+// Empty maps and lists are leaves too.
+func flattenValues(cfg Configuration) map[string]interface{} {
+	leaves := map[string]interface{}{}
+	var flatten func(path string, v interface{})
+	flatten = func(path string, v interface{}) {
+		switch t := v.(type) {
+		case Configuration:
+			flatten(path, map[string]interface{}(t))
+		case map[string]interface{}:
+			if len(t) == 0 && path != "" {
+				leaves[path] = t
+			}
+			for k, sub := range t {
+				flatten(joinPath(path, k), sub)
+			}
+		case []interface{}:
+			if len(t) == 0 {
+				leaves[path] = t
+			}
+			for i, sub := range t {
+				flatten(fmt.Sprintf("%s[%d]", path, i), sub)
+			}
+		default:
+			leaves[path] = v
+		}
+	}
+	if cfg != nil {
+		flatten("", cfg)
+	}
+	return leaves
+}
+
+var plainKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// joinPath appends key to path, quoting keys that contain dots or other special characters
+func joinPath(path, key string) string {
+	if !plainKey.MatchString(key) {
+		return fmt.Sprintf("%s[%s]", path, strconv.Quote(key))
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// formatValue formats a value of a ValueChange for text output
+func formatValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		if t == "" || strings.TrimSpace(t) != t || strings.Contains(t, "\n") {
+			return strconv.Quote(t)
+		}
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case map[string]interface{}:
+		if len(t) == 0 {
+			return "{}"
+		}
+	case []interface{}:
+		if len(t) == 0 {
+			return "[]"
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// String formats the change as a line such as `~ image.tag: 1.2 → 1.3`, `+ replicas: 2` or `- debug: true`
+func (vc *ValueChange) String() string {
+	switch vc.Kind {
+	case ValueAdded:
+		return fmt.Sprintf("+ %s: %s", vc.Path, formatValue(vc.Desired))
+	case ValueRemoved:
+		return fmt.Sprintf("- %s: %s", vc.Path, formatValue(vc.Current))
+	}
+	return fmt.Sprintf("~ %s: %s → %s", vc.Path, formatValue(vc.Current), formatValue(vc.Desired))
+}
+
+// String formats the change as a line such as `~ secret DB_PASSWORD (environment variable DB_PASSWORD)`
+func (sc *SecretChange) String() string {
+	marker := map[string]string{ValueAdded: "+", ValueRemoved: "-", ValueChanged: "~"}[sc.Kind]
+	if sc.Source == "" {
+		return fmt.Sprintf("%s secret %s", marker, sc.Key)
+	}
+	return fmt.Sprintf("%s secret %s (%s)", marker, sc.Key, sc.Source)
+}
+
+// Title describes the action on the component, e.g. `Update: my-component`
+func (d *ComponentDiff) Title() string {
+	switch {
+	case d.Blocked && d.Action == "replace":
+		return fmt.Sprintf("Blocked replace of protected component: %s (%s)", d.Component, d.Reason)
+	case d.Blocked:
+		return fmt.Sprintf("Blocked %s of protected component: %s", d.Action, d.Component)
+	case d.Action == "replace":
+		return fmt.Sprintf("Replace (delete + create): %s", d.Component)
+	}
+	return fmt.Sprintf("%s: %s", strings.Title(d.Action), d.Component)
+}
+
+// Lines returns the changes as indented text lines: the attributes first, then the secrets, then the values
+func (d *ComponentDiff) Lines() []string {
+	lines := []string{}
+	for _, vc := range d.Attributes {
+		lines = append(lines, "  "+vc.String())
+	}
+	for _, sc := range d.Secrets {
+		lines = append(lines, "  "+sc.String())
+	}
+	for _, vc := range d.Values {
+		lines = append(lines, "  "+vc.String())
+	}
+	return lines
+}
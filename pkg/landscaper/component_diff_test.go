@@ -0,0 +1,124 @@
+package landscaper
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffComponents(t *testing.T) {
+	defaults := Configuration{
+		"image":     map[string]interface{}{"repository": "nginx", "tag": "1.2"},
+		"replicas":  float64(1),
+		"resources": map[string]interface{}{},
+	}
+
+	cur := newTestComponent("web")
+	cur.ChartDefaults = defaults
+	cur.Configuration = Configuration{
+		"image":     map[string]interface{}{"repository": "nginx", "tag": "1.2"},
+		"replicas":  float64(1),
+		"resources": map[string]interface{}{},
+		"debug":     true,
+		"args":      []interface{}{"--verbose"},
+		"Name":      "web",
+	}
+	cur.Configuration.SetMetadata(&Metadata{ChartRepository: "repo", ReleaseVersion: "1.0.0"})
+
+	des := newTestComponent("web")
+	des.ChartDefaults = defaults
+	des.Configuration = Configuration{
+		"image":          map[string]interface{}{"repository": "nginx", "tag": "1.3"},
+		"replicas":       float64(1),
+		"resources":      map[string]interface{}{"limits": map[string]interface{}{"cpu": "500m"}},
+		"args":           []interface{}{"--verbose", "--port=80"},
+		"podAnnotations": map[string]interface{}{"prometheus.io/scrape": "true"},
+		"Name":           "web",
+	}
+	des.Configuration.SetMetadata(&Metadata{ChartRepository: "repo", ReleaseVersion: "1.1.0"})
+	des.Release.Version = "1.1.0"
+	des.SecretValues = SecretValues{"TestSecret1": []byte("rotated"), "TestSecret2": []byte("secret value 2")}
+	des.SecretSources = map[string]string{"TestSecret1": "environment variable TEST_SECRET_1"}
+
+	d := diffComponents(cur, des)
+	require.Equal(t, []string{
+		"  ~ release.version: 1.0.0 → 1.1.0",
+		"  ~ secret TestSecret1 (environment variable TEST_SECRET_1)",
+		"  + args[1]: --port=80",
+		"  - debug: true",
+		"  ~ image.tag: 1.2 → 1.3",
+		`  + podAnnotations["prometheus.io/scrape"]: true`,
+		"  + resources.limits.cpu: 500m",
+	}, d.Lines())
+
+	// a create shows the values that differ from the chart's defaults
+	d = diffComponents(nil, des)
+	require.Equal(t, "web", d.Component)
+	require.Len(t, d.Values, 5)
+	require.Equal(t, &ValueChange{Path: "image.tag", Kind: ValueAdded, Desired: "1.3"}, d.Values[2])
+
+	// without chart defaults, every value is shown
+	des.ChartDefaults = nil
+	require.Len(t, diffComponents(nil, des).Values, 7)
+}
+
+func TestDiffSecrets(t *testing.T) {
+	cur := newTestComponent("web")
+	cur.SecretValues = SecretValues{"OLD_TOKEN": []byte("old"), "DB_PASSWORD": []byte("s3cr3t"), "API_KEY": []byte("same")}
+	cur.SecretSources = map[string]string{"OLD_TOKEN": "Kubernetes secret myNameSpace/web"}
+
+	des := newTestComponent("web")
+	des.SecretValues = SecretValues{"DB_PASSWORD": []byte("rotated"), "API_KEY": []byte("same"), "NEW_TOKEN": []byte("new")}
+	des.SecretSources = map[string]string{"DB_PASSWORD": "environment variable DB_PASSWORD", "NEW_TOKEN": "Azure Key Vault kv, secret new-token"}
+
+	changes := diffSecrets(cur, des)
+	require.Equal(t, []*SecretChange{
+		{Key: "DB_PASSWORD", Kind: ValueChanged, Source: "environment variable DB_PASSWORD"},
+		{Key: "NEW_TOKEN", Kind: ValueAdded, Source: "Azure Key Vault kv, secret new-token"},
+		{Key: "OLD_TOKEN", Kind: ValueRemoved, Source: "Kubernetes secret myNameSpace/web"},
+	}, changes)
+	require.Equal(t, "DB_PASSWORD, NEW_TOKEN, OLD_TOKEN", secretKeys(changes))
+	require.Equal(t, "- secret OLD_TOKEN (Kubernetes secret myNameSpace/web)", changes[2].String())
+
+	// values never end up in the text or YAML output
+	changesBuf := &bytes.Buffer{}
+	c := &Changes{Create: Components{}, Update: Components{des.Name: des}, Delete: Components{}, Forced: map[string]bool{}, Adopt: map[string]bool{}}
+	require.NoError(t, c.WriteDiff(changesBuf, Components{cur.Name: cur}))
+	require.NoError(t, c.WriteDiffYAML(changesBuf, Components{cur.Name: cur}))
+	for _, value := range []string{"old", "s3cr3t", "rotated", "same", "new"} {
+		require.NotContains(t, changesBuf.String(), ": "+value+"\n")
+	}
+	require.Empty(t, diffSecrets(cur, cur))
+}
+
+func TestChangesWriteDiff(t *testing.T) {
+	cur := newTestComponent("web")
+	des := newTestComponent("web")
+	des.Configuration["FlushSize"] = float64(4)
+	nu := newTestComponent("new")
+
+	changes := &Changes{
+		Create: Components{nu.Name: nu},
+		Update: Components{des.Name: des},
+		Delete: Components{},
+		Forced: map[string]bool{},
+		Adopt:  map[string]bool{},
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, changes.WriteDiff(buf, Components{cur.Name: cur}))
+	require.Contains(t, buf.String(), "Update: web\n  ~ FlushSize: 3 → 4\nCreate: new\n")
+	require.NotContains(t, buf.String(), metadataKey)
+
+	buf = &bytes.Buffer{}
+	require.NoError(t, changes.WriteDiffYAML(buf, Components{cur.Name: cur}))
+	require.Contains(t, buf.String(), `- action: update
+  component: web
+  values:
+  - current: 3
+    desired: 4
+    kind: changed
+    path: FlushSize
+`)
+}
@@ -52,11 +52,28 @@ func TestComponentValidate(t *testing.T) {
 	c.Name = "way too long way too long way too long way too long way too long way too long"
 	assert.Error(t, c.Validate())
 
+	// name must be a valid release name, which ends up in Kubernetes object names
+	c = makeTestComp()
+	c.Name = "Prefix-name"
+	assert.Error(t, c.Validate())
+
+	// Helm allows 53 characters
+	c = makeTestComp()
+	c.Name = "exactly-fifty-three-characters-long-release-name-abcd"
+	assert.NoError(t, c.Validate())
+
 	// c.Release.Chart cannot be empty
 	c = makeTestComp()
 	c.Release.Chart = ""
 	assert.Error(t, c.Validate())
 
+	// labels must be valid Kubernetes labels
+	c = makeTestComp()
+	c.Labels = map[string]string{"team": "payments"}
+	assert.NoError(t, c.Validate())
+	c.Labels = map[string]string{"team": "payments and search"}
+	assert.Error(t, c.Validate())
+
 }
 
 func TestComponentEquals(t *testing.T) {
@@ -66,3 +83,36 @@ func TestComponentEquals(t *testing.T) {
 	c1.Name = "other"
 	require.False(t, c0.Equals(c1))
 }
+
+func TestComponentsDependencyOrder(t *testing.T) {
+	cs := Components{
+		"service":  &Component{Name: "service", DependsOn: []string{"database", "broker"}},
+		"broker":   &Component{Name: "broker", DependsOn: []string{"database"}},
+		"database": &Component{Name: "database"},
+		"api":      &Component{Name: "api", DependsOn: []string{"service", "unknown"}},
+	}
+	order, err := cs.dependencyOrder()
+	require.NoError(t, err)
+	require.Equal(t, []string{"database", "broker", "service", "api"}, order)
+	require.Error(t, validateDependencies(cs)) // api depends on an unknown component
+
+	cs["database"].DependsOn = []string{"service"}
+	_, err = cs.dependencyOrder()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "service -> broker -> database -> service")
+}
+
+func TestComponentSetSecretSources(t *testing.T) {
+	cmp := newTestComponent("web")
+	cmp.SecretNames = SecretNames{"TestSecret1": "db-password"}
+
+	cmp.setSecretSources(NewRetryingSecrets(&kubeSecretsProvider{}, RetryPolicy{}))
+	require.Equal(t, "Kubernetes secret myNameSpace/web", cmp.SecretSources["TestSecret2"])
+
+	cmp.setSecretSources(NewEnvironmentSecretsReader())
+	require.Equal(t, map[string]string{"TestSecret1": "environment variable DB_PASSWORD", "TestSecret2": "environment variable TESTSECRET2"}, cmp.SecretSources)
+
+	// a provider that can't tell leaves the sources alone
+	cmp.setSecretSources(SecretsProviderMock{})
+	require.Len(t, cmp.SecretSources, 2)
+}
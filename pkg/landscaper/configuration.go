@@ -1,6 +1,7 @@
 package landscaper
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/ghodss/yaml"
@@ -33,15 +34,104 @@ func (cfg Configuration) GetMetadata() (*Metadata, error) {
 
 	metadata := val.(map[string]interface{})
 
-	return &Metadata{ReleaseVersion: metadata[metaReleaseVersion].(string), ChartRepository: metadata[metaChartRepo].(string)}, nil
+	m := &Metadata{ReleaseVersion: metadata[metaReleaseVersion].(string), ChartRepository: metadata[metaChartRepo].(string)}
+	if deps, ok := metadata[metaDependsOn].([]interface{}); ok {
+		for _, dep := range deps {
+			m.DependsOn = append(m.DependsOn, dep.(string))
+		}
+	}
+	if protect, ok := metadata[metaProtect].(bool); ok {
+		m.Protect = protect
+	}
+	if ls, ok := metadata[metaLabels].(map[string]interface{}); ok {
+		m.Labels = map[string]string{}
+		for k, v := range ls {
+			m.Labels[k] = v.(string)
+		}
+	}
+	if commit, ok := metadata[metaCommit].(string); ok {
+		m.Commit = commit
+	}
+	if hooks, ok := metadata[metaDeleteHooks]; ok {
+		bs, err := json.Marshal(hooks)
+		if err != nil {
+			return nil, err
+		}
+		m.DeleteHooks = &Hooks{}
+		if err := json.Unmarshal(bs, m.DeleteHooks); err != nil {
+			return nil, fmt.Errorf("configuration has bad delete hooks in its metadata: %s", err)
+		}
+	}
+
+	return m, nil
 }
 
-// SetMetadata sets the provided Metadata
+// SetMetadata sets the provided Metadata. Dependencies, protection, labels, the commit and delete hooks are only stored when set, so that releases without them are left untouched.
 func (cfg Configuration) SetMetadata(m *Metadata) {
-	cfg[metadataKey] = map[string]interface{}{
+	metadata := map[string]interface{}{
 		metaReleaseVersion: m.ReleaseVersion,
 		metaChartRepo:      m.ChartRepository,
 	}
+
+	if len(m.DependsOn) > 0 {
+		// stored the way it comes back from a release's values
+		deps := []interface{}{}
+		for _, dep := range m.DependsOn {
+			deps = append(deps, dep)
+		}
+		metadata[metaDependsOn] = deps
+	}
+
+	if m.Protect {
+		metadata[metaProtect] = true
+	}
+
+	if len(m.Labels) > 0 {
+		ls := map[string]interface{}{}
+		for k, v := range m.Labels {
+			ls[k] = v
+		}
+		metadata[metaLabels] = ls
+	}
+
+	if m.Commit != "" {
+		metadata[metaCommit] = m.Commit
+	}
+
+	if m.DeleteHooks != nil {
+		// stored the way it comes back from a release's values
+		var hooks interface{}
+		bs, _ := json.Marshal(m.DeleteHooks)
+		json.Unmarshal(bs, &hooks)
+		metadata[metaDeleteHooks] = hooks
+	}
+
+	cfg[metadataKey] = metadata
+}
+
+// withoutCommit returns cfg, or a shallow copy of it without the commit in its metadata. The commit records where the
+// last change of a release came from; a new commit alone doesn't make a component differ.
+func withoutCommit(cfg Configuration) Configuration {
+	metadata, ok := cfg[metadataKey].(map[string]interface{})
+	if !ok {
+		return cfg
+	}
+	if _, ok := metadata[metaCommit]; !ok {
+		return cfg
+	}
+
+	cp := Configuration{}
+	for k, v := range cfg {
+		cp[k] = v
+	}
+	m := map[string]interface{}{}
+	for k, v := range metadata {
+		if k != metaCommit {
+			m[k] = v
+		}
+	}
+	cp[metadataKey] = m
+	return cp
 }
 
 // Merge two configurations
@@ -16,6 +16,7 @@ import (
 	podutil "k8s.io/kubernetes/pkg/api/pod"
 	"k8s.io/kubernetes/pkg/apis/core"
 	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
+	batchinternal "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/batch/internalversion"
 	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/core/internalversion"
 )
 
@@ -41,8 +42,28 @@ type Environment struct {
 	AzureKeyVault             string        // Azure keyvault to use for secrets if provided
 	Environment               string        // Environment selections
 	ConfigurationOverrideFile string        // Global configuration overrides file
+	Adopt                     bool          // Take over existing releases that are not controlled by landscaper
+	Parallelism               int           // Number of components to handle concurrently
+	AutoRollback              bool          // Roll back releases whose upgrade failed
+	RollbackAll               bool          // On failure, roll back every component changed in the run
+	ContinueOnError           bool          // Keep applying the other components when a component fails
+	MaxDeletions              int           // Refuse to delete more components than this; 0 means no limit
+	MaxDeletionPercentage     float64       // Refuse to delete more than this percentage of the components; 0 means no limit
+	AllowMassDeletion         bool          // Ignore MaxDeletions and MaxDeletionPercentage
+	SecretsUpdateStrategy     string        // How pods get to use changed secret values: restart or recreate
+	Only                      []string      // Glob patterns of the names of the components to handle; empty means all
+	Skip                      []string      // Glob patterns of the names of the components to leave alone
+	Selector                  string        // Label selector of the components to handle
+	Retry                     RetryPolicy   // Retry calls to Tiller and Kubernetes that fail with a transient error
+	HooksFile                 string        // Landscape hooks file, with hooks that run for every component
+	RunTests                  bool          // Run the chart tests of every created or updated component
+	IgnoreDifferences         []string      // Value paths whose differences don't cause an update, for every component
+	RedactKeys                []string      // Patterns of the keys whose values are redacted in logs and diffs
+	GitRepo                   string        // Local git repository to read the component files from, instead of the file system
+	GitRef                    string        // Commit, branch or tag in GitRepo to read the component files at
 	helmClient                helm.Interface
 	kubeClient                internalversion.CoreInterface
+	batchClient               batchinternal.BatchInterface
 	DisabledStages            stringSlice // stages to disable during landscaper apply
 }
 
@@ -95,6 +116,8 @@ func (e *Environment) HelmClient() helm.Interface {
 		if !compatible {
 			logrus.Warn("Helm and Tiller report incompatible version numbers")
 		}
+
+		e.helmClient = NewRetryingHelmClient(e.helmClient, e.Retry)
 	}
 
 	return e.helmClient
@@ -125,6 +148,20 @@ func (e *Environment) KubeClient() internalversion.CoreInterface {
 	return e.kubeClient
 }
 
+// BatchClient makes sure the environment has a Kubernetes batch client initialized, to run Jobs with
+func (e *Environment) BatchClient() batchinternal.BatchInterface {
+	if e.batchClient == nil {
+		_, client, err := getKubeClient(e.Context)
+		if err != nil {
+			logrus.WithField("error", err).Fatalf("Could not build Kubernetes client config")
+			return nil
+		}
+		e.batchClient = client.Batch()
+	}
+
+	return e.batchClient
+}
+
 // Teardown closes the tunnel
 func (e *Environment) Teardown() {
 	teardown()
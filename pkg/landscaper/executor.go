@@ -1,19 +1,31 @@
 package landscaper
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
+	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/pmezard/go-difflib/difflib"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/release"
 )
 
+// maxReleaseHistory is the number of revisions searched for the deployed one
+const maxReleaseHistory = 256
+
 // Executor is responsible for applying a desired landscape to the actual landscape
 type Executor interface {
-	Apply(Components, Components) (map[string][]string, error)
+	Apply(Components, Components) (*ApplyResult, error)
+	Diff(Components, Components) (*Changes, error)
+	ApplyChanges(*Changes, Components) (*ApplyResult, error)
+	CheckAdoptions(*Changes) error
 
 	CreateComponent(*Component) error
 	UpdateComponent(*Component) error
@@ -21,18 +33,176 @@ type Executor interface {
 }
 
 type executor struct {
-	helmClient     helm.Interface
-	chartLoader    ChartLoader
-	kubeSecrets    SecretsWriteDeleter
-	dryRun         bool
-	wait           bool
-	waitTimeout    int64
-	disabledStages []string
+	helmClient            helm.Interface
+	chartLoader           ChartLoader
+	kubeSecrets           SecretsWriteDeleter
+	dryRun                bool
+	wait                  bool
+	waitTimeout           int64
+	disabledStages        []string
+	adopt                 bool
+	parallelism           int
+	autoRollback          bool
+	rollbackAll           bool
+	continueOnErr         bool
+	deletionGuard         *DeletionGuard
+	secretsUpdateStrategy string
+	hookRunner            HookRunner
+	landscapeHooks        *Hooks
+	testAll               bool
+	ignoreDifferences     []string
+	retry                 RetryPolicy
+	interrupt             func() error
+}
+
+// DeletionGuard protects against deleting a large part of the landscape by accident, e.g. because of a wrong directory
+// or environment. The deletions that replace a component (delete + create) don't count.
+type DeletionGuard struct {
+	MaxDeletions  int     // the number of deletions allowed; 0 means no limit
+	MaxPercentage float64 // the percentage of the current components that may be deleted; 0 means no limit
+	Override      bool    // allow any number of deletions
+
+	// Confirm is asked whether to go ahead when a limit is exceeded. If nil, the changes are refused.
+	Confirm func(deletions []string, total int) bool
+}
+
+// phase is a set of components that are created, updated or deleted together
+type phase struct {
+	stage     string
+	ordered   []*Component        // the components in the order to handle them
+	waitFor   map[string][]string // per component, the components of the phase that must be done before it starts
+	blockedBy map[string][]string // per component, the components whose failure prevents it from being handled
+}
+
+// ComponentError is a failure to create, update or delete a single component
+type ComponentError struct {
+	Component string
+	Stage     string
+	Err       error
+}
+
+func (e *ComponentError) Error() string {
+	return fmt.Sprintf("%s of `%s` failed: %s", e.Stage, e.Component, e.Err)
+}
+
+// ApplyErrors holds all failures that occurred while applying changes
+type ApplyErrors []*ComponentError
+
+func (es ApplyErrors) Error() string {
+	if len(es) == 1 {
+		return es[0].Error()
+	}
+
+	msgs := []string{fmt.Sprintf("%d components failed:", len(es))}
+	for _, e := range es {
+		msgs = append(msgs, "\t* "+e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ExecutorOption configures optional behaviour of an Executor
+type ExecutorOption func(*executor)
+
+// WithAdoption makes the Executor take over releases that are not controlled by landscaper but have the name of a
+// to-be-created component, by upgrading them in place. Without it, such components are refused.
+func WithAdoption(adopt bool) ExecutorOption {
+	return func(e *executor) {
+		e.adopt = adopt
+	}
+}
+
+// WithParallelism makes the Executor handle up to n components of the same phase concurrently. Components still wait
+// for the components they depend on. n <= 1 handles components one by one.
+func WithParallelism(n int) ExecutorOption {
+	return func(e *executor) {
+		e.parallelism = n
+	}
+}
+
+// WithAutoRollback makes the Executor roll back a release to its last deployed revision when upgrading it fails.
+// With all, every component changed in the run is rolled back as well when anything fails: updated releases are rolled
+// back and created releases deleted. Deleted releases cannot be restored.
+func WithAutoRollback(autoRollback, all bool) ExecutorOption {
+	return func(e *executor) {
+		e.autoRollback = autoRollback
+		e.rollbackAll = all
+	}
+}
+
+// WithContinueOnError makes the Executor carry on with the other components when a component fails, instead of stopping.
+// Components that depend on a failed component are skipped. All failures are returned as ApplyErrors.
+func WithContinueOnError(continueOnErr bool) ExecutorOption {
+	return func(e *executor) {
+		e.continueOnErr = continueOnErr
+	}
+}
+
+// WithDeletionGuard makes the Executor refuse changes that delete more components than the guard allows
+func WithDeletionGuard(guard DeletionGuard) ExecutorOption {
+	return func(e *executor) {
+		e.deletionGuard = &guard
+	}
+}
+
+// The strategies to get pods to use changed secret values
+const (
+	SecretsUpdateRestart  = "restart"  // update the release with a new secrets checksum, for a rolling update of the pods that template it
+	SecretsUpdateRecreate = "recreate" // delete and create the release
+)
+
+// WithSecretsUpdateStrategy sets how the Executor handles components whose secret values changed: SecretsUpdateRestart,
+// the default, or SecretsUpdateRecreate
+func WithSecretsUpdateStrategy(strategy string) ExecutorOption {
+	return func(e *executor) {
+		e.secretsUpdateStrategy = strategy
+	}
+}
+
+// WithHooks makes the Executor run hooks with runner: the landscape hooks for every component, followed by the hooks of
+// the component itself. A failing pre hook aborts the component's action; a failing post hook fails the component.
+func WithHooks(runner HookRunner, landscape *Hooks) ExecutorOption {
+	return func(e *executor) {
+		e.hookRunner = runner
+		e.landscapeHooks = landscape
+	}
+}
+
+// WithReleaseTests makes the Executor run the chart tests of every created or updated component, instead of only those of
+// the components that ask for it with test: true
+func WithReleaseTests(all bool) ExecutorOption {
+	return func(e *executor) {
+		e.testAll = all
+	}
+}
+
+// WithIgnoreDifferences makes the Executor ignore differences at the given value paths for every component, in addition
+// to the component's own ignoreDifferences rules
+func WithIgnoreDifferences(rules []string) ExecutorOption {
+	return func(e *executor) {
+		e.ignoreDifferences = rules
+	}
+}
+
+// WithRetry makes the Executor retry installs, upgrades, rollbacks and deletes that fail with a transient error,
+// according to policy. Before every retry the release is checked, so a call whose response was lost isn't repeated.
+func WithRetry(policy RetryPolicy) ExecutorOption {
+	return func(e *executor) {
+		e.retry = policy
+	}
+}
+
+// WithInterrupt makes the Executor ask interrupt before every phase whether it must stop, e.g. because the landscape
+// lock was lost. If interrupt returns an error, no further phase is started and the error is returned; nothing is
+// rolled back.
+func WithInterrupt(interrupt func() error) ExecutorOption {
+	return func(e *executor) {
+		e.interrupt = interrupt
+	}
 }
 
 // NewExecutor is a factory method to create a new Executor
-func NewExecutor(helmClient helm.Interface, chartLoader ChartLoader, kubeSecrets SecretsWriteDeleter, dryRun bool, wait bool, waitTimeout int64, disabledStages []string) Executor {
-	return &executor{
+func NewExecutor(helmClient helm.Interface, chartLoader ChartLoader, kubeSecrets SecretsWriteDeleter, dryRun bool, wait bool, waitTimeout int64, disabledStages []string, opts ...ExecutorOption) Executor {
+	e := &executor{
 		helmClient:     helmClient,
 		chartLoader:    chartLoader,
 		kubeSecrets:    kubeSecrets,
@@ -40,26 +210,36 @@ func NewExecutor(helmClient helm.Interface, chartLoader ChartLoader, kubeSecrets
 		wait:           wait,
 		waitTimeout:    waitTimeout,
 		disabledStages: disabledStages,
+		hookRunner:     NewHookRunner(nil),
+	}
+	for _, opt := range opts {
+		opt(e)
 	}
+	return e
 }
 
-// gatherForcedUpdates returns a map that for each to-be-updated component indicates if it needs a forced update.
-// there may be several reasons to do so: releases that differ only in secret values are forced so that pods will restart with the new values; releases that differ in namespace cannot be updated
-func (e *executor) gatherForcedUpdates(current, update Components) (map[string]bool, error) {
-	needForcedUpdate := map[string]bool{}
+// gatherForcedUpdates returns for each to-be-updated component that needs a forced update the reason to do so.
+// there may be several reasons to do so: with the recreate strategy, releases that differ only in secret values are forced so that pods will restart with the new values; releases that differ in namespace cannot be updated
+func (e *executor) gatherForcedUpdates(current, update Components) (map[string]string, error) {
+	needForcedUpdate := map[string]string{}
 
 	for _, cmp := range update {
-		// releases that differ only in secret values are forced so that pods will restart with the new values
 		for _, curCmp := range current {
 			if curCmp.Name == cmp.Name && isOnlySecretValueDiff(*curCmp, *cmp) {
-				logrus.Infof("%s differs in secrets values only; don't update but delete + create instead", cmp.Name)
-				needForcedUpdate[cmp.Name] = true
+				keys := secretKeys(diffSecrets(curCmp, cmp))
+				if e.secretsUpdateStrategy != SecretsUpdateRecreate {
+					// pods that template the secrets checksum restart through a rolling update
+					logrus.Infof("%s differs in secrets values only (%s); update %s", cmp.Name, keys, secretsChecksumKey)
+					continue
+				}
+				logrus.Infof("%s differs in secrets values only (%s); don't update but delete + create instead", cmp.Name, keys)
+				needForcedUpdate[cmp.Name] = fmt.Sprintf("%s: %s", ForcedBySecrets, keys)
 			}
 		}
 		if curCmp := current[cmp.Name]; curCmp != nil {
 			if curCmp.Namespace != cmp.Namespace {
 				logrus.Infof("%s differs in namespace; don't update but delete + create instead", cmp.Name)
-				needForcedUpdate[cmp.Name] = true
+				needForcedUpdate[cmp.Name] = ForcedByNamespace
 			}
 		}
 	}
@@ -67,70 +247,769 @@ func (e *executor) gatherForcedUpdates(current, update Components) (map[string]b
 	return needForcedUpdate, nil
 }
 
-// Apply transforms the current state into the desired state
-func (e *executor) Apply(desired, current Components) (map[string][]string, error) {
-	result := make(map[string][]string)
-	result["create"] = []string{}
-	result["update"] = []string{}
-	result["delete"] = []string{}
+// gatherAdoptions returns a map that indicates for each to-be-created component whether it collides with an existing
+// release that is not controlled by landscaper. Unless adoption is enabled, such a collision is an error.
+func (e *executor) gatherAdoptions(create Components) (map[string]bool, error) {
+	needAdoption := map[string]bool{}
+
+	for _, cmp := range create {
+		res, err := e.helmClient.ListReleases(helm.ReleaseListFilter(fmt.Sprintf("^%s$", regexp.QuoteMeta(cmp.Name))))
+		if err != nil {
+			return nil, errors.New(grpc.ErrorDesc(err))
+		}
+
+		for _, release := range res.GetReleases() {
+			if release.Name != cmp.Name {
+				continue
+			}
+
+			cfg, err := getReleaseConfiguration(release)
+			if err != nil {
+				return nil, err
+			}
+			if cfg.HasMetadata() {
+				continue
+			}
+
+			if !e.adopt {
+				return nil, fmt.Errorf("release `%s` already exists but is not controlled by landscaper; enable adoption to take it over", cmp.Name)
+			}
+			if release.Namespace != cmp.Namespace {
+				return nil, fmt.Errorf("cannot adopt release `%s`: it lives in namespace `%s` instead of `%s`", cmp.Name, release.Namespace, cmp.Namespace)
+			}
+
+			logrus.Infof("%s exists but is not controlled by landscaper; adopt it by updating it in place", cmp.Name)
+			needAdoption[cmp.Name] = true
+		}
+	}
+
+	return needAdoption, nil
+}
+
+// CheckAdoptions makes sure that the releases changes adopts are still exactly those that need adoption, e.g. when a
+// plan is applied: a release installed outside landscaper since must not be overwritten, and one that landscaper took
+// over since must not be adopted again
+func (e *executor) CheckAdoptions(changes *Changes) error {
+	candidates := Components{}
+	for name, cmp := range changes.Create {
+		candidates[name] = cmp
+	}
+	for name, cmp := range changes.Update {
+		if changes.Adopt[name] {
+			candidates[name] = cmp
+		}
+	}
+
+	needAdoption, err := e.gatherAdoptions(candidates)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range candidates.names() {
+		switch {
+		case needAdoption[name] && !changes.Adopt[name]:
+			return fmt.Errorf("release `%s` was installed outside landscaper since the changes were determined", name)
+		case !needAdoption[name] && changes.Adopt[name]:
+			return fmt.Errorf("release `%s` no longer needs to be adopted; it was removed or taken over since the changes were determined", name)
+		}
+	}
+	return nil
+}
+
+// Diff determines the Changes needed to transform the current state into the desired state
+func (e *executor) Diff(desired, current Components) (*Changes, error) {
+	if len(e.ignoreDifferences) > 0 {
+		withRules := Components{}
+		for name, cmp := range desired {
+			cp := *cmp
+			cp.IgnoreDifferences = append(append([]string{}, e.ignoreDifferences...), cmp.IgnoreDifferences...)
+			withRules[name] = &cp
+		}
+		desired = withRules
+	}
 
 	create, update, delete := diff(desired, current)
 
 	// some to-be-updated components need a delete + create instead
-	needForcedUpdate, err := e.gatherForcedUpdates(current, update)
+	forcedReasons, err := e.gatherForcedUpdates(current, update)
 	if err != nil {
-		return result, err
+		return nil, err
+	}
+	needForcedUpdate := map[string]bool{}
+	for name := range forcedReasons {
+		needForcedUpdate[name] = true
 	}
 
-	// delete+create pairs will never work in dry run since the dry-run "deleted" component will exist in create
-	if !e.dryRun {
-		create, update, delete = integrateForcedUpdates(current, create, update, delete, needForcedUpdate)
+	// to-be-created components may collide with releases that landscaper doesn't control yet
+	needAdoption, err := e.gatherAdoptions(create)
+	if err != nil {
+		return nil, err
 	}
+	create, update = integrateAdoptions(create, update, needAdoption)
 
-	for _, cmp := range delete {
-		cmpForcedUpdate := needForcedUpdate[cmp.Name]
-		if e.stageEnabled("delete") || (e.stageEnabled("update") && cmpForcedUpdate) {
-			logrus.Infof("Delete: %s", cmp.Name)
-			if err := e.DeleteComponent(cmp); err != nil {
-				logrus.WithFields(logrus.Fields{"error": err, "component": cmp}).Error("DeleteComponent failed")
-				return result, err
-			}
-			result["delete"] = append(result["delete"], cmp.Name)
+	// protected components are neither deleted nor replaced
+	update, delete, blocked := integrateProtection(current, update, delete, needForcedUpdate)
+	reasons := map[string]string{}
+	for name, reason := range forcedReasons {
+		if needForcedUpdate[name] || blocked[name] != "" {
+			reasons[name] = reason
 		}
 	}
+	forcedReasons = reasons
+
+	create, update, delete = integrateForcedUpdates(current, create, update, delete, needForcedUpdate)
+
+	return &Changes{Create: create, Update: update, Delete: delete, Forced: needForcedUpdate, ForcedReasons: forcedReasons, Adopt: needAdoption, Blocked: blocked}, nil
+}
+
+// Apply transforms the current state into the desired state
+func (e *executor) Apply(desired, current Components) (*ApplyResult, error) {
+	changes, err := e.Diff(desired, current)
+	if err != nil {
+		return &ApplyResult{Components: []*ComponentResult{}}, err
+	}
+
+	return e.ApplyChanges(changes, current)
+}
 
+// ApplyChanges performs the given changes to the current state. Deletes happen first, then updates, then creates, and
+// last the updates of components that depend on a created one. Within each phase components are handled in dependency
+// order (deletes in reverse), up to parallelism at a time.
+func (e *executor) ApplyChanges(changes *Changes, current Components) (*ApplyResult, error) {
+	result := &ApplyResult{Components: []*ComponentResult{}}
+
+	needForcedUpdate := changes.Forced
+
+	// leave out the components of disabled stages
+	create, update, delete := Components{}, Components{}, Components{}
+	for name, cmp := range changes.Delete {
+		if e.stageEnabled("delete") || (e.stageEnabled("update") && needForcedUpdate[name]) {
+			delete[name] = cmp
+		}
+	}
 	if e.stageEnabled("update") {
-		for _, cmp := range update {
-			if err := logDifferences(logrus.Infof, "Update: "+cmp.Name, current[cmp.Name], cmp); err != nil {
-				return result, err
+		for name, cmp := range changes.Update {
+			update[name] = cmp
+		}
+	}
+	for name, cmp := range changes.Create {
+		if e.stageEnabled("create") || (e.stageEnabled("update") && needForcedUpdate[name]) {
+			create[name] = cmp
+		}
+	}
+
+	if err := e.checkDeletions(delete, current, needForcedUpdate); err != nil {
+		return result, err
+	}
+
+	for _, name := range sortedNames(changes.Blocked) {
+		cr := &ComponentResult{Component: name}
+		switch changes.Blocked[name] {
+		case "delete":
+			if !e.stageEnabled("delete") {
+				continue
 			}
-			if err := e.UpdateComponent(cmp); err != nil {
-				logrus.WithFields(logrus.Fields{"error": err, "component": cmp}).Error("UpdateComponent failed")
-				return result, err
+			cr.Action = "delete"
+			cr.BlockedReason = "component is protected; not deleting it"
+		case "replace":
+			if !e.stageEnabled("update") {
+				continue
 			}
-			result["update"] = append(result["update"], cmp.Name)
+			cr.Action = "update"
+			cr.ForcedReason = changes.ForcedReasons[name]
+			cr.BlockedReason = fmt.Sprintf("component is protected; not replacing it although %s", cr.ForcedReason)
+		}
+		if cur := current[name]; cur != nil {
+			cr.RevisionBefore, cr.RevisionAfter = cur.Revision, cur.Revision
 		}
+		logrus.WithFields(logrus.Fields{"component": name, "action": changes.Blocked[name]}).Warn("Blocked action on protected component")
+		result.Components = append(result.Components, cr)
 	}
 
-	for _, cmp := range create {
-		cmpForcedUpdate := needForcedUpdate[cmp.Name]
-		if e.stageEnabled("create") || (e.stageEnabled("update") && cmpForcedUpdate) {
-			if err := logDifferences(logrus.Infof, "Create: "+cmp.Name, nil, cmp); err != nil {
+	// the landscape as it will be after applying the changes; dependencies of creates and updates are resolved through it
+	next := Components{}
+	for _, cs := range []Components{current, update, create} {
+		for name, cmp := range cs {
+			next[name] = cmp
+		}
+	}
+
+	deleteOrder, err := inDependencyOrder(delete, current)
+	if err != nil {
+		return result, err
+	}
+	for i, j := 0, len(deleteOrder)-1; i < j; i, j = i+1, j-1 {
+		deleteOrder[i], deleteOrder[j] = deleteOrder[j], deleteOrder[i]
+	}
+	// an update that depends on a created component, e.g. because it gained a dependsOn, has to wait for the creates
+	earlyUpdate, lateUpdate, err := splitUpdates(update, create, next)
+	if err != nil {
+		return result, err
+	}
+	updateOrder, err := inDependencyOrder(earlyUpdate, next)
+	if err != nil {
+		return result, err
+	}
+	createOrder, err := inDependencyOrder(create, next)
+	if err != nil {
+		return result, err
+	}
+	lateUpdateOrder, err := inDependencyOrder(lateUpdate, next)
+	if err != nil {
+		return result, err
+	}
+
+	// remember what to roll back to, before anything changes
+	revisions := map[string]int32{}
+	if e.autoRollback && !e.dryRun {
+		for name := range update {
+			if revisions[name], err = e.deployedRevision(name); err != nil {
 				return result, err
 			}
+		}
+	}
 
-			if err := e.CreateComponent(cmp); err != nil {
-				logrus.WithFields(logrus.Fields{"error": err, "component": cmp}).Error("CreateComponent failed")
-				return result, err
+	deletePhase := phase{stage: "delete", ordered: deleteOrder, waitFor: invertDependencies(dependenciesWithin(delete, current))}
+	deletePhase.blockedBy = deletePhase.waitFor // a component can't go while a dependant remains
+	updatePhase := phase{stage: "update", ordered: updateOrder, waitFor: dependenciesWithin(earlyUpdate, next), blockedBy: map[string][]string{}}
+	createPhase := phase{stage: "create", ordered: createOrder, waitFor: dependenciesWithin(create, next), blockedBy: map[string][]string{}}
+	lateUpdatePhase := phase{stage: "update", ordered: lateUpdateOrder, waitFor: dependenciesWithin(lateUpdate, next), blockedBy: map[string][]string{}}
+	for _, p := range []phase{updatePhase, createPhase, lateUpdatePhase} {
+		for _, cmp := range p.ordered {
+			p.blockedBy[cmp.Name] = next.allDependencies(cmp.Name)
+		}
+	}
+
+	errs := ApplyErrors{}
+	failed := map[string]string{} // the components that failed or were skipped, and which of the two
+
+	// record adds the outcomes of a phase to result, and tells whether to carry on
+	record := func(crs []*ComponentResult, phaseErrs ApplyErrors) bool {
+		for _, cr := range crs {
+			cr.Forced = needForcedUpdate[cr.Component]
+			cr.ForcedReason = changes.ForcedReasons[cr.Component]
+			cr.Adopted = cr.Action == "update" && changes.Adopt[cr.Component]
+			if cur := current[cr.Component]; cur != nil && cr.Action != "create" {
+				cr.RevisionBefore = cur.Revision
 			}
-			result["create"] = append(result["create"], cmp.Name)
 		}
+		result.Components = append(result.Components, crs...)
+		errs = append(errs, phaseErrs...)
+		return len(errs) == 0 || e.continueOnErr
 	}
 
-	logrus.WithFields(logrus.Fields{"created": len(result["create"]), "updated": len(result["update"]), "deleted": len(result["delete"])}).Info("Applied desired state successfully")
+	if err := e.interrupted(); err != nil {
+		return result, err
+	}
+	if !record(e.runPhase(deletePhase, failed, e.withHooks("delete", current, changes.Create, func(cmp *Component, log logrus.FieldLogger) (int32, error) {
+		if needForcedUpdate[cmp.Name] {
+			log.Infof("Replace (delete + create): %s; %s", cmp.Name, changes.ForcedReasons[cmp.Name])
+		} else {
+			log.Infof("Delete: %s", cmp.Name)
+		}
+		if err := e.deleteComponent(cmp, log); err != nil {
+			log.WithFields(logrus.Fields{"error": err, "component": cmp.Name}).Error("DeleteComponent failed")
+			return 0, err
+		}
+		return 0, nil
+	}))) {
+		return result, e.rollback(result, errs, revisions, current, next, changes)
+	}
+
+	updateFn := e.withHooks("update", current, nil, func(cmp *Component, log logrus.FieldLogger) (int32, error) {
+		action := "Update: "
+		if changes.Adopt[cmp.Name] {
+			action = "Adopt: "
+		}
+		logDifferences(log.Infof, action+cmp.Name, current[cmp.Name], cmp)
+		revision, err := e.updateComponent(cmp, log)
+		if err != nil {
+			log.WithFields(logrus.Fields{"error": err, "component": cmp.Name}).Error("UpdateComponent failed")
+			return 0, err
+		}
+		return revision, e.testComponent(cmp, cmp.Name, log)
+	})
+
+	if err := e.interrupted(); err != nil {
+		return result, err
+	}
+	if !record(e.runPhase(updatePhase, failed, updateFn)) {
+		return result, e.rollback(result, errs, revisions, current, next, changes)
+	}
+
+	if err := e.interrupted(); err != nil {
+		return result, err
+	}
+	if !record(e.runPhase(createPhase, failed, e.withHooks("create", current, nil, func(cmp *Component, log logrus.FieldLogger) (int32, error) {
+		action, base, releaseName := "Create: ", (*Component)(nil), cmp.Name
+		if needForcedUpdate[cmp.Name] {
+			action, base = "Replace (delete + create): ", current[cmp.Name]
+			if e.dryRun {
+				// the release wasn't really deleted, so let Tiller pick a name for the simulated install
+				releaseName = ""
+			}
+		}
+		logDifferences(log.Infof, action+cmp.Name, base, cmp)
+		revision, err := e.createComponent(cmp, releaseName, log)
+		if err != nil {
+			log.WithFields(logrus.Fields{"error": err, "component": cmp.Name}).Error("CreateComponent failed")
+			return 0, err
+		}
+		return revision, e.testComponent(cmp, releaseName, log)
+	}))) {
+		return result, e.rollback(result, errs, revisions, current, next, changes)
+	}
+
+	if len(lateUpdateOrder) > 0 {
+		if err := e.interrupted(); err != nil {
+			return result, err
+		}
+		record(e.runPhase(lateUpdatePhase, failed, updateFn))
+	}
+	if len(errs) > 0 {
+		return result, e.rollback(result, errs, revisions, current, next, changes)
+	}
+
+	logrus.WithFields(logrus.Fields{"created": len(result.Succeeded("create")), "updated": len(result.Succeeded("update")), "deleted": len(result.Succeeded("delete"))}).Info("Applied desired state successfully")
 	return result, nil
 }
 
+// splitUpdates splits update into the components that can be updated before the creates, and those that depend on a
+// component in create, directly or through next, and so are updated after them. A create that depends on one of the
+// latter can't be ordered; that is an error.
+func splitUpdates(update, create, next Components) (Components, Components, error) {
+	early, late := Components{}, Components{}
+	for name, cmp := range update {
+		early[name] = cmp
+		for _, dep := range next.allDependencies(name) {
+			if _, ok := create[dep]; ok {
+				late[name] = cmp
+				delete(early, name)
+				break
+			}
+		}
+	}
+
+	for _, name := range create.names() {
+		for _, dep := range next.allDependencies(name) {
+			if _, ok := late[dep]; ok {
+				return nil, nil, fmt.Errorf("cannot order `%s`: it depends on `%s`, which must be updated after a component that is created in the same run; apply the components it depends on first, e.g. with --only", name, dep)
+			}
+		}
+	}
+
+	return early, late, nil
+}
+
+// interrupted returns the reason to stop applying, if there is one
+func (e *executor) interrupted() error {
+	if e.interrupt == nil {
+		return nil
+	}
+	return e.interrupt()
+}
+
+// withHooks wraps fn, which performs action on a component, with the pre and post hooks for that action. The hooks are
+// those of the component in desired, if it is there, and otherwise the component's own: a component that is deleted
+// to be replaced runs the hooks of its replacement, and one that is deleted for good the delete hooks kept in its
+// metadata.
+func (e *executor) withHooks(action string, current, desired Components, fn func(*Component, logrus.FieldLogger) (int32, error)) func(*Component, logrus.FieldLogger) (int32, error) {
+	return func(cmp *Component, log logrus.FieldLogger) (int32, error) {
+		hooks := cmp.Hooks
+		if d := desired[cmp.Name]; d != nil {
+			hooks = d.Hooks
+		}
+
+		env := HookEnv{Component: cmp.Name, Namespace: cmp.Namespace, Action: action}
+		if cur := current[cmp.Name]; cur != nil && action != "create" {
+			env.Revision = cur.Revision
+		}
+		if err := e.runHooks("pre", hooks, env, log); err != nil {
+			return 0, err
+		}
+
+		revision, err := fn(cmp, log)
+		if err != nil {
+			return revision, err
+		}
+
+		env.Revision = revision
+		return revision, e.runHooks("post", hooks, env, log)
+	}
+}
+
+// runHooks runs the landscape's pre or post hooks for the action in env, followed by those of hooks, one by one. It
+// stops at the first hook that fails. In dry-run, the hooks are only logged.
+func (e *executor) runHooks(when string, hooks *Hooks, env HookEnv, log logrus.FieldLogger) error {
+	for _, hook := range append(e.landscapeHooks.forAction(when, env.Action), hooks.forAction(when, env.Action)...) {
+		log := log.WithFields(logrus.Fields{"component": env.Component, "hook": hook.String()})
+		if e.dryRun {
+			log.Infof("Dry run; not running %s-%s hook", when, env.Action)
+			continue
+		}
+
+		log.Infof("Running %s-%s hook", when, env.Action)
+		if err := e.hookRunner.Run(hook, env, log); err != nil {
+			log.WithFields(logrus.Fields{"error": err}).Errorf("%s-%s hook failed", when, env.Action)
+			return fmt.Errorf("%s-%s %s", when, env.Action, err)
+		}
+	}
+	return nil
+}
+
+// sortedNames returns the keys of m, sorted
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkDeletions makes sure the deletions don't exceed the limits of the deletion guard, or are confirmed otherwise.
+// In dry-run, exceeding a limit only results in a warning.
+func (e *executor) checkDeletions(delete, current Components, forced map[string]bool) error {
+	g := e.deletionGuard
+	if g == nil || g.Override {
+		return nil
+	}
+
+	deletions := []string{}
+	for _, name := range delete.names() {
+		if !forced[name] {
+			deletions = append(deletions, name)
+		}
+	}
+
+	exceeded := ""
+	switch {
+	case g.MaxDeletions > 0 && len(deletions) > g.MaxDeletions:
+		exceeded = fmt.Sprintf("the maximum of %d deletions", g.MaxDeletions)
+	case g.MaxPercentage > 0 && len(current) > 0 && float64(len(deletions))*100/float64(len(current)) > g.MaxPercentage:
+		exceeded = fmt.Sprintf("the maximum of %g%% of the current components", g.MaxPercentage)
+	default:
+		return nil
+	}
+
+	if e.dryRun {
+		logrus.Warnf("Deleting %d of %d components exceeds %s; a real run would refuse", len(deletions), len(current), exceeded)
+		return nil
+	}
+	if g.Confirm != nil && g.Confirm(deletions, len(current)) {
+		return nil
+	}
+
+	return fmt.Errorf("refusing to delete %d of %d components (%s): exceeds %s", len(deletions), len(current), strings.Join(deletions, ", "), exceeded)
+}
+
+// rollback rolls back the failed updates in errs and, when rolling back everything, the components that were changed
+// successfully. The rollbacks are added to result, and failed rollbacks to the returned errors.
+func (e *executor) rollback(result *ApplyResult, errs ApplyErrors, revisions map[string]int32, current, next Components, changes *Changes) ApplyErrors {
+	if !e.autoRollback || e.dryRun {
+		return errs
+	}
+
+	// rollbackOne rolls back a single component with undo, and records the outcome
+	rollbackOne := func(name string, revisionBefore int32, undo func() (int32, error)) {
+		cr := &ComponentResult{Component: name, Action: "rollback", RevisionBefore: revisionBefore}
+		start := time.Now()
+		revision, err := undo()
+		cr.setDuration(time.Since(start))
+		cr.RevisionAfter = revision
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"error": err, "component": name}).Error("Rollback failed")
+			cr.Error = err.Error()
+			errs = append(errs, &ComponentError{Component: name, Stage: "rollback", Err: err})
+		}
+		result.Components = append(result.Components, cr)
+	}
+
+	// the updates to roll back, with the revision they brought about
+	toRollback := []string{}
+	revisionsAfter := map[string]int32{}
+	for _, err := range errs {
+		if err.Stage == "update" {
+			toRollback = append(toRollback, err.Component)
+		}
+	}
+
+	if e.rollbackAll {
+		// undo in the reverse order of applying
+		created := result.Succeeded("create")
+		for i := len(created) - 1; i >= 0; i-- {
+			name := created[i]
+			if changes.Forced[name] {
+				continue // replaced a release that was deleted; see below
+			}
+			logrus.Infof("Rollback: delete %s", name)
+			rollbackOne(name, result.revisionAfter(name, "create"), func() (int32, error) {
+				return 0, e.deleteComponent(next[name], logrus.StandardLogger())
+			})
+		}
+		updated := result.Succeeded("update")
+		for i := len(updated) - 1; i >= 0; i-- {
+			toRollback = append(toRollback, updated[i])
+			revisionsAfter[updated[i]] = result.revisionAfter(updated[i], "update")
+		}
+		for _, name := range result.Succeeded("delete") {
+			logrus.Warnf("Rollback: %s has been deleted and cannot be restored", name)
+		}
+	}
+
+	for _, name := range toRollback {
+		name := name
+		logrus.Infof("Rollback: %s to revision %d", name, revisions[name])
+		rollbackOne(name, revisionsAfter[name], func() (int32, error) {
+			return e.rollbackComponent(name, revisions[name], current[name])
+		})
+	}
+
+	return errs
+}
+
+// deployedRevision returns the revision of the given release that is currently deployed
+func (e *executor) deployedRevision(name string) (int32, error) {
+	res, err := e.helmClient.ReleaseHistory(name, helm.WithMaxHistory(maxReleaseHistory))
+	if err != nil {
+		return 0, errors.New(grpc.ErrorDesc(err))
+	}
+
+	var revision int32
+	for _, r := range res.GetReleases() {
+		if r.GetInfo().GetStatus().GetCode() == release.Status_DEPLOYED && r.Version > revision {
+			revision = r.Version
+		}
+	}
+	if revision == 0 {
+		return 0, fmt.Errorf("release `%s` has no deployed revision", name)
+	}
+
+	return revision, nil
+}
+
+// rollbackComponent rolls the named release back to revision and returns the new revision. previous is the component as it was before the run, if
+// landscaper controlled it; its secrets are restored, since Helm doesn't keep track of them.
+func (e *executor) rollbackComponent(name string, revision int32, previous *Component) (int32, error) {
+	if previous != nil && len(previous.SecretValues) > 0 {
+		if err := e.kubeSecrets.Delete(previous.Name, previous.Namespace); err != nil {
+			return 0, err
+		}
+		if err := e.kubeSecrets.Write(previous.Name, previous.Namespace, previous.SecretValues); err != nil {
+			return 0, err
+		}
+	}
+
+	newRevision, err := e.retryWrite("RollbackRelease", name, func() (int32, error) {
+		res, err := e.helmClient.RollbackRelease(
+			name,
+			helm.RollbackVersion(revision),
+			helm.RollbackWait(e.wait),
+			helm.RollbackTimeout(e.waitTimeout),
+		)
+		return res.GetRelease().GetVersion(), err
+	})
+	if err != nil {
+		return 0, errors.New(grpc.ErrorDesc(err))
+	}
+
+	return newRevision, nil
+}
+
+// latestRelease returns the latest revision of the named release, or nil if there is no such release
+func (e *executor) latestRelease(name string) (*release.Release, error) {
+	res, err := e.helmClient.ReleaseHistory(name, helm.WithMaxHistory(1))
+	if err != nil {
+		if strings.Contains(grpc.ErrorDesc(err), "not found") {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var latest *release.Release
+	for _, r := range res.GetReleases() {
+		if r.Version > latest.GetVersion() {
+			latest = r
+		}
+	}
+	return latest, nil
+}
+
+// retryWrite calls fn, which installs, upgrades or rolls back the named release and returns the new revision, and
+// retries it when it fails with a transient error. A retry is only made while the release has no revision newer than
+// before the first call; a newer revision means the call took effect. If that revision isn't deployed, the call
+// failed or is still going on, and repeating it would only make things worse.
+func (e *executor) retryWrite(operation, name string, fn func() (int32, error)) (int32, error) {
+	var revision int32
+	call := func() (err error) {
+		revision, err = fn()
+		return err
+	}
+
+	if e.retry.Attempts <= 1 || e.dryRun {
+		err := e.retry.do(operation, call)
+		return revision, err
+	}
+
+	before, err := e.latestRelease(name)
+	if err != nil {
+		return 0, err
+	}
+
+	err = e.retry.doChecked(operation, call, func() (bool, error) {
+		latest, err := e.latestRelease(name)
+		if err != nil {
+			return false, err
+		}
+		if latest.GetVersion() <= before.GetVersion() {
+			return false, nil
+		}
+		if status := latest.GetInfo().GetStatus().GetCode(); status != release.Status_DEPLOYED {
+			return false, fmt.Errorf("release `%s` has revision %d with status %s after a failed %s", name, latest.Version, status, operation)
+		}
+		revision = latest.Version
+		return true, nil
+	})
+	return revision, err
+}
+
+// runPhase applies fn to the components of p and returns the outcome per component. fn returns the Helm revision it
+// brought about. Without parallelism the components are handled one by one; otherwise up to e.parallelism components are
+// handled at a time, each after the components it waits for are done, and their log lines are collected per component.
+// After an error no new components are started, unless continuing on errors; the components that weren't started are
+// skipped. When continuing on errors, only the components blocked by a failed or skipped component are skipped; failed
+// holds those and is updated as the phase progresses.
+func (e *executor) runPhase(p phase, failed map[string]string, fn func(*Component, logrus.FieldLogger) (int32, error)) ([]*ComponentResult, ApplyErrors) {
+	results := []*ComponentResult{}
+	errs := ApplyErrors{}
+
+	// skipReason tells why the named component cannot be handled, if it can't
+	skipReason := func(name string) string {
+		if failed[name] != "" {
+			return fmt.Sprintf("an earlier action on `%s` %s", name, failed[name]) // e.g. the delete of a forced update failed
+		}
+		for _, b := range p.blockedBy[name] {
+			if failed[b] != "" {
+				return fmt.Sprintf("`%s` %s", b, failed[b])
+			}
+		}
+		return ""
+	}
+	skip := func(name, reason string) {
+		logrus.Warnf("Skip: %s, since %s", name, reason)
+		failed[name] = "was skipped"
+		results = append(results, &ComponentResult{Component: name, Action: p.stage, SkippedReason: reason})
+	}
+	handle := func(cmp *Component, log logrus.FieldLogger) (*ComponentResult, error) {
+		cr := &ComponentResult{Component: cmp.Name, Action: p.stage}
+		start := time.Now()
+		revision, err := fn(cmp, log)
+		cr.setDuration(time.Since(start))
+		cr.RevisionAfter = revision
+		if err != nil {
+			cr.Error = err.Error()
+		}
+		return cr, err
+	}
+	fail := func(name string, err error) {
+		failed[name] = "failed"
+		errs = append(errs, &ComponentError{Component: name, Stage: p.stage, Err: err})
+	}
+
+	// stopReason tells why no new components are started, if that is the case
+	stopReason := func() string {
+		if len(errs) == 0 || e.continueOnErr {
+			return ""
+		}
+		return fmt.Sprintf("applying stopped after `%s` failed", errs[0].Component)
+	}
+
+	if e.parallelism <= 1 {
+		for _, cmp := range p.ordered {
+			reason := stopReason()
+			if reason == "" {
+				reason = skipReason(cmp.Name)
+			}
+			if reason != "" {
+				skip(cmp.Name, reason)
+				continue
+			}
+			cr, err := handle(cmp, logrus.StandardLogger())
+			results = append(results, cr)
+			if err != nil {
+				fail(cmp.Name, err)
+			}
+		}
+		return results, errs
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done := map[string]chan struct{}{}
+	for _, cmp := range p.ordered {
+		done[cmp.Name] = make(chan struct{})
+	}
+	slots := make(chan struct{}, e.parallelism)
+
+	for _, cmp := range p.ordered {
+		wg.Add(1)
+		go func(cmp *Component) {
+			defer wg.Done()
+			defer close(done[cmp.Name])
+
+			for _, dep := range p.waitFor[cmp.Name] {
+				<-done[dep]
+			}
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			mu.Lock()
+			reason := stopReason()
+			if reason == "" {
+				reason = skipReason(cmp.Name)
+			}
+			if reason != "" {
+				skip(cmp.Name, reason)
+				mu.Unlock()
+				return
+			}
+			mu.Unlock()
+
+			log, flush := newBufferedLogger()
+			cr, err := handle(cmp, log)
+			flush()
+
+			mu.Lock()
+			defer mu.Unlock()
+			results = append(results, cr)
+			if err != nil {
+				fail(cmp.Name, err)
+			}
+		}(cmp)
+	}
+	wg.Wait()
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Component < errs[j].Component })
+	return results, errs
+}
+
+// logOutput serializes writing collected log lines to the output of the standard logger
+var logOutput sync.Mutex
+
+// newBufferedLogger returns a logger that is configured like the standard logger, but collects its lines until flush
+// writes them to the standard logger's output in one go
+func newBufferedLogger() (logrus.FieldLogger, func()) {
+	std := logrus.StandardLogger()
+	buf := &bytes.Buffer{}
+	log := &logrus.Logger{Out: buf, Formatter: std.Formatter, Hooks: std.Hooks, Level: std.Level}
+
+	return log, func() {
+		logOutput.Lock()
+		defer logOutput.Unlock()
+		std.Out.Write(buf.Bytes())
+	}
+}
+
 func (e *executor) stageEnabled(stage string) bool {
 	for _, stageDisabled := range e.disabledStages {
 		if stageDisabled == stage {
@@ -142,27 +1021,33 @@ func (e *executor) stageEnabled(stage string) bool {
 
 // CreateComponent creates the given Component
 func (e *executor) CreateComponent(cmp *Component) error {
+	_, err := e.createComponent(cmp, cmp.Name, logrus.StandardLogger())
+	return err
+}
+
+// createComponent installs cmp as a release named releaseName, or with a name generated by Tiller if releaseName is
+// empty, and returns the revision of the release
+func (e *executor) createComponent(cmp *Component, releaseName string, log logrus.FieldLogger) (int32, error) {
 	// We need to ensure the chart is available on the local system. LoadChart will ensure
 	// this is the case by downloading the chart if it is not there yet
 	chartRef, err := cmp.FullChartRef()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	_, chartPath, err := e.chartLoader.Load(chartRef)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	rawValues, err := cmp.Configuration.YAML()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	logrus.WithFields(logrus.Fields{
-		"release":   cmp.Name,
+	log.WithFields(logrus.Fields{
+		"release":   releaseName,
 		"chart":     cmp.Release.Chart,
 		"chartPath": chartPath,
-		"rawValues": rawValues,
 		"values":    cmp.Configuration,
 		"dryrun":    e.dryRun,
 	}).Debug("Create component")
@@ -170,43 +1055,52 @@ func (e *executor) CreateComponent(cmp *Component) error {
 	if len(cmp.SecretValues) > 0 && !e.dryRun {
 		err = e.kubeSecrets.Write(cmp.Name, cmp.Namespace, cmp.SecretValues)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
-	_, err = e.helmClient.InstallRelease(
-		chartPath,
-		cmp.Namespace,
-		helm.ValueOverrides([]byte(rawValues)),
-		helm.ReleaseName(cmp.Name),
-		helm.InstallDryRun(e.dryRun),
-		helm.InstallReuseName(true),
-		helm.InstallWait(e.wait),
-		helm.InstallTimeout(e.waitTimeout),
-	)
+	revision, err := e.retryWrite("InstallRelease", releaseName, func() (int32, error) {
+		res, err := e.helmClient.InstallRelease(
+			chartPath,
+			cmp.Namespace,
+			helm.ValueOverrides([]byte(rawValues)),
+			helm.ReleaseName(releaseName),
+			helm.InstallDryRun(e.dryRun),
+			helm.InstallReuseName(true),
+			helm.InstallWait(e.wait),
+			helm.InstallTimeout(e.waitTimeout),
+		)
+		return res.GetRelease().GetVersion(), err
+	})
 	if err != nil {
-		return errors.New(grpc.ErrorDesc(err))
+		return 0, errors.New(grpc.ErrorDesc(err))
 	}
 
-	return nil
+	return revision, nil
 }
 
 // UpdateComponent updates the given Component
 func (e *executor) UpdateComponent(cmp *Component) error {
+	_, err := e.updateComponent(cmp, logrus.StandardLogger())
+	return err
+}
+
+// updateComponent updates cmp and returns the new revision of its release
+func (e *executor) updateComponent(cmp *Component, log logrus.FieldLogger) (int32, error) {
 	// We need to ensure the chart is available on the local system. LoadChart will ensure
 	// this is the case by downloading the chart if it is not there yet
 	chartRef, err := cmp.FullChartRef()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	_, chartPath, err := e.chartLoader.Load(chartRef)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	rawValues, err := cmp.Configuration.YAML()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if !e.dryRun {
@@ -217,12 +1111,12 @@ func (e *executor) UpdateComponent(cmp *Component) error {
 		if len(cmp.SecretValues) > 0 {
 			err = e.kubeSecrets.Write(cmp.Name, cmp.Namespace, cmp.SecretValues)
 			if err != nil {
-				return err
+				return 0, err
 			}
 		}
 	}
 
-	logrus.WithFields(logrus.Fields{
+	log.WithFields(logrus.Fields{
 		"release":   cmp.Name,
 		"chart":     cmp.Release.Chart,
 		"chartPath": chartPath,
@@ -230,24 +1124,79 @@ func (e *executor) UpdateComponent(cmp *Component) error {
 		"dryrun":    e.dryRun,
 	}).Debug("Update component")
 
-	_, err = e.helmClient.UpdateRelease(
-		cmp.Name,
-		chartPath,
-		helm.UpdateValueOverrides([]byte(rawValues)),
-		helm.UpgradeDryRun(e.dryRun),
-		helm.UpgradeWait(e.wait),
-		helm.UpgradeTimeout(e.waitTimeout),
-	)
+	revision, err := e.retryWrite("UpdateRelease", cmp.Name, func() (int32, error) {
+		res, err := e.helmClient.UpdateRelease(
+			cmp.Name,
+			chartPath,
+			helm.UpdateValueOverrides([]byte(rawValues)),
+			helm.UpgradeDryRun(e.dryRun),
+			helm.UpgradeWait(e.wait),
+			helm.UpgradeTimeout(e.waitTimeout),
+		)
+		return res.GetRelease().GetVersion(), err
+	})
 	if err != nil {
-		return errors.New(grpc.ErrorDesc(err))
+		return 0, errors.New(grpc.ErrorDesc(err))
+	}
+
+	return revision, nil
+}
+
+// testComponent runs the chart tests of the release of cmp, if it should be tested, and logs their output. It fails when
+// a test fails. In dry-run, the tests are only logged.
+func (e *executor) testComponent(cmp *Component, releaseName string, log logrus.FieldLogger) error {
+	if !cmp.Test && !e.testAll {
+		return nil
+	}
+
+	log = log.WithFields(logrus.Fields{"component": cmp.Name, "release": releaseName})
+	if e.dryRun {
+		log.Info("Dry run; not running release tests")
+		return nil
+	}
+
+	log.Info("Running release tests")
+	responses, errc := e.helmClient.RunReleaseTest(releaseName, helm.ReleaseTestTimeout(e.waitTimeout))
+
+	failures := 0
+	for responses != nil || errc != nil {
+		select {
+		case res, ok := <-responses:
+			if !ok {
+				responses = nil
+				continue
+			}
+			if res.Status == release.TestRun_FAILURE {
+				failures++
+				log.Error(res.Msg)
+			} else {
+				log.Info(res.Msg)
+			}
+		case err, ok := <-errc:
+			if !ok {
+				errc = nil
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("running tests of release `%s` failed: %s", releaseName, grpc.ErrorDesc(err))
+			}
+		}
 	}
 
+	if failures > 0 {
+		return fmt.Errorf("%d test(s) of release `%s` failed", failures, releaseName)
+	}
+	log.Info("Release tests passed")
 	return nil
 }
 
 // DeleteComponent removes the given Component
 func (e *executor) DeleteComponent(cmp *Component) error {
-	logrus.WithFields(logrus.Fields{
+	return e.deleteComponent(cmp, logrus.StandardLogger())
+}
+
+func (e *executor) deleteComponent(cmp *Component, log logrus.FieldLogger) error {
+	log.WithFields(logrus.Fields{
 		"release": cmp.Name,
 		"values":  cmp.Configuration,
 		"dryrun":  e.dryRun,
@@ -261,11 +1210,18 @@ func (e *executor) DeleteComponent(cmp *Component) error {
 	}
 
 	if !e.dryRun {
-		_, err := e.helmClient.DeleteRelease(
-			cmp.Name,
-			helm.DeletePurge(true),
-			helm.DeleteDryRun(e.dryRun),
-		)
+		// a purged release is gone, so a delete whose response was lost needn't be repeated
+		err := e.retry.doChecked("DeleteRelease", func() error {
+			_, err := e.helmClient.DeleteRelease(
+				cmp.Name,
+				helm.DeletePurge(true),
+				helm.DeleteDryRun(e.dryRun),
+			)
+			return err
+		}, func() (bool, error) {
+			latest, err := e.latestRelease(cmp.Name)
+			return latest == nil, err
+		})
 		if err != nil {
 			return errors.New(grpc.ErrorDesc(err))
 		}
@@ -299,49 +1255,12 @@ func diff(desired, current Components) (Components, Components, Components) {
 	return create, update, delete
 }
 
-// componentDiffText returns a diff as text. current and desired can be nil and indicate non-existence (e.g. current nil and desired non-nil means: create)
-func componentDiffText(current, desired *Component) (string, error) {
-	cText, dText := []string{}, []string{}
-	cName, dName := "<none>", "<none>"
-	if current != nil {
-		cs, err := json.MarshalIndent(current, "", "  ")
-		if err != nil {
-			return "", err
-		}
-		cText = difflib.SplitLines(string(cs))
-		cName = current.Name
-	}
-	if desired != nil {
-		ds, err := json.MarshalIndent(desired, "", "  ")
-		if err != nil {
-			return "", err
-		}
-		dText = difflib.SplitLines(string(ds))
-		dName = desired.Name
-	}
-
-	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
-		A:        cText,
-		FromFile: "Current " + cName,
-		B:        dText,
-		ToFile:   "Desired " + dName,
-		Context:  3,
-	})
-}
-
-func logDifferences(logf func(format string, args ...interface{}), action string, current, desired *Component) error {
-	diff, err := componentDiffText(current, desired)
-	if err != nil {
-		return err
-	}
+// logDifferences logs action, followed by the differences between current and desired, one line per change
+func logDifferences(logf func(format string, args ...interface{}), action string, current, desired *Component) {
 	logf("%s", action)
-	if diff != "" {
-		logf("Diff:\n%s", diff)
-	}
-	if current != nil && desired != nil && !reflect.DeepEqual(current.SecretValues, desired.SecretValues) {
-		logrus.Info("Diff: secrets have changed, not shown here")
+	for _, line := range diffComponents(current, desired).Lines() {
+		logf("%s", line)
 	}
-	return nil
 }
 
 // integrateForcedUpdates removes forceUpdate from update and inserts it into delete + create
@@ -360,10 +1279,84 @@ func integrateForcedUpdates(current, create, update, delete Components, forceUpd
 	return create, fixUpdate, delete
 }
 
-// isOnlySecretValueDiff tells whether the given Components differ in their .SecretValues fields and are identical otherwise
+// integrateProtection removes the protected components from delete, and those that need a forced update from update.
+// It returns for each of them the action that was blocked. Components are protected when either their current or their
+// desired state says so, so that protection can't be lifted in the same change that would otherwise delete the release.
+// A protected component that is only forced because its secret values changed doesn't need its release deleted; it
+// stays a plain update that rewrites its secrets.
+func integrateProtection(current, update, delete Components, forceUpdate map[string]bool) (Components, Components, map[string]string) {
+	blocked := map[string]string{}
+
+	fixDelete := Components{}
+	for name, cmp := range delete {
+		if cmp.Protect {
+			blocked[name] = "delete"
+		} else {
+			fixDelete[name] = cmp
+		}
+	}
+
+	fixUpdate := Components{}
+	for name, cmp := range update {
+		if forceUpdate[name] && (cmp.Protect || (current[name] != nil && current[name].Protect)) {
+			forceUpdate[name] = false
+			if current[name] != nil && isOnlySecretValueDiff(*current[name], *cmp) {
+				logrus.Infof("%s is protected; update its secrets instead of delete + create", name)
+				fixUpdate[name] = cmp
+				continue
+			}
+			blocked[name] = "replace"
+		} else {
+			fixUpdate[name] = cmp
+		}
+	}
+
+	return fixUpdate, fixDelete, blocked
+}
+
+// integrateAdoptions moves the to-be-adopted components from create to update
+func integrateAdoptions(create, update Components, adopt map[string]bool) (Components, Components) {
+	fixCreate := Components{}
+	for _, cmp := range create {
+		if adopt[cmp.Name] {
+			update[cmp.Name] = cmp
+		} else {
+			fixCreate[cmp.Name] = cmp
+		}
+	}
+	return fixCreate, update
+}
+
+// isOnlySecretValueDiff tells whether the given Components differ in their .SecretValues fields and are identical otherwise.
+// The checksum of the secret values in their configurations is disregarded, since it follows the secret values. a is the
+// current component and b the desired one, whose ignoreDifferences rules apply to both.
 func isOnlySecretValueDiff(a, b Component) bool {
 	secValsEqual := reflect.DeepEqual(a.SecretValues, b.SecretValues)
 	a.SecretValues = SecretValues{}
 	b.SecretValues = SecretValues{}
+	a.Revision, b.Revision = 0, 0
+	a.Hooks, b.Hooks = nil, nil
+	a.Test, b.Test = false, false
+	a.ChartDefaults, b.ChartDefaults = nil, nil
+	a.SecretSources, b.SecretSources = nil, nil
+	a.Configuration = withoutIgnored(withoutCommit(a.Configuration), b.IgnoreDifferences)
+	b.Configuration = withoutIgnored(withoutCommit(b.Configuration), b.IgnoreDifferences)
+	a.IgnoreDifferences, b.IgnoreDifferences = nil, nil
+	a.Configuration = withoutKey(a.Configuration, secretsChecksumKey)
+	b.Configuration = withoutKey(b.Configuration, secretsChecksumKey)
 	return !secValsEqual && reflect.DeepEqual(a, b)
 }
+
+// withoutKey returns a shallow copy of cfg without key
+func withoutKey(cfg Configuration, key string) Configuration {
+	if _, ok := cfg[key]; !ok {
+		return cfg
+	}
+	cp := Configuration{}
+	for k, v := range cfg {
+		if k != key {
+			cp[k] = v
+		}
+	}
+	return cp
+}
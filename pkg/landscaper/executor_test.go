@@ -1,10 +1,15 @@
 package landscaper
 
 import (
+	"bytes"
+	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"k8s.io/helm/pkg/helm"
 	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
 	"k8s.io/helm/pkg/proto/hapi/services"
 
 	"errors"
@@ -89,12 +94,12 @@ func TestExecutorApply(t *testing.T) {
 
 	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages).Apply(des, cur)
 	require.NoError(t, err)
-	require.Equal(t, len(result["create"]), 1)
-	require.Equal(t, len(result["update"]), 1)
-	require.Equal(t, len(result["delete"]), 1)
-	require.Equal(t, result["create"][0], nu.Name)
-	require.Equal(t, result["update"][0], updiff.Name)
-	require.Equal(t, result["delete"][0], rem.Name)
+	require.Equal(t, len(result.Succeeded("create")), 1)
+	require.Equal(t, len(result.Succeeded("update")), 1)
+	require.Equal(t, len(result.Succeeded("delete")), 1)
+	require.Equal(t, result.Succeeded("create")[0], nu.Name)
+	require.Equal(t, result.Succeeded("update")[0], updiff.Name)
+	require.Equal(t, result.Succeeded("delete")[0], rem.Name)
 }
 
 func TestExecutorApplyWithForcedUpdatesAndDeleteCreateDisableWhenExistingSecretValueChanges(t *testing.T) {
@@ -146,13 +151,846 @@ func TestExecutorApplyWithForcedUpdatesAndDeleteCreateDisableWhenExistingSecretV
 
 	createDeleteDisabled := []string{"create", "delete"}
 
-	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, createDeleteDisabled).Apply(des, cur)
+	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, createDeleteDisabled, WithSecretsUpdateStrategy(SecretsUpdateRecreate)).Apply(des, cur)
 	require.NoError(t, err)
-	require.Equal(t, len(result["create"]), 1)
-	require.Equal(t, len(result["update"]), 0)
-	require.Equal(t, len(result["delete"]), 1)
-	require.Equal(t, result["create"][0], updiff.Name)
-	require.Equal(t, result["delete"][0], updiff.Name)
+	require.Equal(t, len(result.Succeeded("create")), 1)
+	require.Equal(t, len(result.Succeeded("update")), 0)
+	require.Equal(t, len(result.Succeeded("delete")), 1)
+	require.Equal(t, result.Succeeded("create")[0], updiff.Name)
+	require.Equal(t, result.Succeeded("delete")[0], updiff.Name)
+}
+
+func TestExecutorApplyRestartsWhenSecretValuesChange(t *testing.T) {
+	up := newTestComponent("updated-one")
+	up.setSecretsChecksum()
+	updiff := newTestComponent("updated-one")
+	updiff.SecretValues["TestSecret2"] = []byte("new secret value 2")
+	updiff.setSecretsChecksum()
+	require.NotEqual(t, up.Configuration[secretsChecksumKey], updiff.Configuration[secretsChecksumKey])
+	require.True(t, isOnlySecretValueDiff(*up, *updiff))
+
+	des := Components{updiff.Name: updiff}
+	cur := Components{up.Name: up}
+
+	updated := []string{}
+	helmMock := &HelmclientMock{
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			updated = append(updated, rlsName)
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+	written := SecretValues{}
+	secretsMock := SecretsProviderMock{
+		write: func(componentName, namespace string, values SecretValues) error {
+			written = values
+			return nil
+		},
+		delete: func(componentName, namespace string) error {
+			return nil
+		},
+	}
+
+	changes, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages).Diff(des, cur)
+	require.NoError(t, err)
+	require.Empty(t, changes.Delete)
+	require.Empty(t, changes.Create)
+	require.Equal(t, Components{updiff.Name: updiff}, changes.Update)
+	require.False(t, changes.Forced[updiff.Name])
+
+	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages).Apply(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, []string{updiff.Name}, result.Succeeded("update"))
+	require.Equal(t, []string{updiff.Name}, updated)
+	require.Equal(t, updiff.SecretValues, written)
+}
+
+func TestExecutorDiffWithForcedUpdates(t *testing.T) {
+	nu := newTestComponent("new-one")
+	rem := newTestComponent("busted-one")
+	up := newTestComponent("updated-one")
+	updiff := newTestComponent("updated-one")
+	updiff.Configuration["FlushSize"] = 4
+	moved := newTestComponent("moved-one")
+	movediff := newTestComponent("moved-one")
+	movediff.Namespace = "elsewhere"
+
+	des := Components{nu.Name: nu, updiff.Name: updiff, movediff.Name: movediff}
+	cur := Components{rem.Name: rem, up.Name: up, moved.Name: moved}
+
+	changes, err := NewExecutor(&HelmclientMock{}, nil, nil, false, false, waitTimeout, disabledStages).Diff(des, cur)
+	require.NoError(t, err)
+	require.False(t, changes.Empty())
+	require.Equal(t, Components{nu.Name: nu, movediff.Name: movediff}, changes.Create)
+	require.Equal(t, Components{updiff.Name: updiff}, changes.Update)
+	require.Equal(t, Components{rem.Name: rem, moved.Name: moved}, changes.Delete)
+	require.Equal(t, map[string]bool{movediff.Name: true}, changes.Forced)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, changes.WriteDiff(buf, cur))
+	out := buf.String()
+	require.Contains(t, out, "Delete: busted-one")
+	require.Contains(t, out, "Update: updated-one")
+	require.Contains(t, out, "Create: new-one")
+	require.Contains(t, out, "Replace (delete + create): moved-one")
+	require.NotContains(t, out, "Delete: moved-one")
+
+	changes, err = NewExecutor(&HelmclientMock{}, nil, nil, false, false, waitTimeout, disabledStages).Diff(des, des)
+	require.NoError(t, err)
+	require.True(t, changes.Empty())
+}
+
+func TestExecutorApplyProtectedComponents(t *testing.T) {
+	rem := newTestComponent("busted-one")
+	moved := newTestComponent("moved-one")
+	movediff := newTestComponent("moved-one")
+	movediff.Namespace = "elsewhere"
+	rotated := newTestComponent("rotated-one")
+	rotateddiff := newTestComponent("rotated-one")
+	rotateddiff.SecretValues["TestSecret1"] = []byte("rotated")
+	for _, cmp := range []*Component{rem, moved, rotated, rotateddiff} {
+		cmp.Protect = true
+		cmp.Configuration.SetMetadata(&Metadata{ReleaseVersion: cmp.Release.Version, Protect: true})
+	}
+	movediff.Configuration.SetMetadata(&Metadata{ReleaseVersion: movediff.Release.Version}) // lifting protection isn't enough
+	moved.Revision = 3
+
+	des := Components{movediff.Name: movediff, rotateddiff.Name: rotateddiff}
+	cur := Components{rem.Name: rem, moved.Name: moved, rotated.Name: rotated}
+
+	updated := []string{}
+	written := []string{}
+	helmMock := &HelmclientMock{
+		deleteRelease: func(rlsName string, opts ...helm.DeleteOption) (*services.UninstallReleaseResponse, error) {
+			t.Errorf("protected release `%s` deleted", rlsName)
+			return nil, nil
+		},
+		installRelease: func(chStr, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			t.Errorf("release installed in `%s`", namespace)
+			return nil, nil
+		},
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			updated = append(updated, rlsName)
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+	secretsMock := SecretsProviderMock{
+		write: func(componentName, namespace string, values SecretValues) error {
+			written = append(written, componentName)
+			return nil
+		},
+		delete: func(componentName, namespace string) error {
+			return nil
+		},
+	}
+	executor := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages, WithSecretsUpdateStrategy(SecretsUpdateRecreate))
+
+	// a change of secret values alone doesn't need the release deleted, so it becomes a plain update
+	changes, err := executor.Diff(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{rem.Name: "delete", moved.Name: "replace"}, changes.Blocked)
+	require.Equal(t, []string{rotated.Name}, changes.Update.names())
+	require.False(t, changes.Forced[rotated.Name])
+	require.NotContains(t, changes.ForcedReasons, rotated.Name)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, changes.WriteDiff(buf, cur))
+	require.Contains(t, buf.String(), "Blocked delete of protected component: busted-one")
+	require.Contains(t, buf.String(), "Blocked replace of protected component: moved-one (namespace changed)")
+	require.NotContains(t, buf.String(), "Blocked replace of protected component: rotated-one")
+
+	result, err := executor.Apply(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, []string{rem.Name, moved.Name}, result.Blocked())
+	require.Equal(t, "delete", result.Components[0].Action)
+	require.Equal(t, "update", result.Components[1].Action)
+	require.Equal(t, "component is protected; not replacing it although namespace changed", result.Components[1].BlockedReason)
+	require.Equal(t, int32(3), result.Components[1].RevisionAfter)
+	require.Equal(t, []string{rotated.Name}, result.Succeeded("update"))
+	require.Equal(t, []string{rotated.Name}, updated)
+	require.Equal(t, []string{rotated.Name}, written)
+}
+
+func TestComponentProtectMetadata(t *testing.T) {
+	cfg := Configuration{}
+	cfg.SetMetadata(&Metadata{ReleaseVersion: "1.0.0"})
+	require.NotContains(t, cfg[metadataKey], metaProtect)
+
+	cfg.SetMetadata(&Metadata{ReleaseVersion: "1.0.0", Protect: true})
+	cmp := NewComponent("db", "ns", &Release{Chart: "postgres:1.0.0", Version: "1.0.0"}, cfg, nil, nil)
+	require.True(t, cmp.Protect)
+}
+
+func TestExecutorApplyDryRunReplacements(t *testing.T) {
+	moved := newTestComponent("moved-one")
+	movediff := newTestComponent("moved-one")
+	movediff.Namespace = "elsewhere"
+
+	des := Components{movediff.Name: movediff}
+	cur := Components{moved.Name: moved}
+
+	installed := []string{}
+	helmMock := &HelmclientMock{
+		installRelease: func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			installed = append(installed, namespace)
+			return nil, nil
+		},
+		deleteRelease: func(rlsName string, opts ...helm.DeleteOption) (*services.UninstallReleaseResponse, error) {
+			t.Errorf("release `%s` deleted in dry run", rlsName)
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+	executor := NewExecutor(helmMock, chartLoadMock, SecretsProviderMock{}, true, false, waitTimeout, disabledStages)
+
+	changes, err := executor.Diff(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, Components{movediff.Name: movediff}, changes.Create)
+	require.Equal(t, Components{moved.Name: moved}, changes.Delete)
+	require.Empty(t, changes.Update)
+	require.Equal(t, ForcedByNamespace, changes.ForcedReasons[movediff.Name])
+
+	result, err := executor.Apply(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, []string{"elsewhere"}, installed)
+	require.Equal(t, []string{movediff.Name}, result.Succeeded("delete"))
+	require.Equal(t, []string{movediff.Name}, result.Succeeded("create"))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, result.WriteTable(buf))
+	require.Contains(t, buf.String(), "replace (create)")
+	require.Contains(t, buf.String(), "forced: namespace changed")
+}
+
+func TestExecutorApplyAdoptsUnmanagedRelease(t *testing.T) {
+	chartPath := "/opt/store/whatever/path/"
+
+	nu := newTestComponent("hand-installed")
+	des := Components{nu.Name: nu}
+	cur := Components{}
+
+	installed := 0
+	updated := 0
+	helmMock := &HelmclientMock{
+		listReleases: func(opts ...helm.ReleaseListOption) (*services.ListReleasesResponse, error) {
+			return &services.ListReleasesResponse{Releases: []*release.Release{{
+				Name:      "hand-installed",
+				Namespace: nu.Namespace,
+				Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "connector-hdfs", Version: "0.1.0"}},
+				Config:    &chart.Config{Raw: "FlushSize: 1\n"},
+			}}}, nil
+		},
+		installRelease: func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			installed++
+			return nil, nil
+		},
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			require.Equal(t, "hand-installed", rlsName)
+			updated++
+			return nil, nil
+		}}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, chartPath, nil
+	})
+	secretsMock := SecretsProviderMock{
+		write: func(componentName, namespace string, values SecretValues) error {
+			return nil
+		},
+		delete: func(componentName, namespace string) error {
+			return nil
+		},
+	}
+
+	// refuse without adoption
+	_, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages).Apply(des, cur)
+	require.Error(t, err)
+	require.Equal(t, 0, installed+updated)
+
+	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages, WithAdoption(true)).Apply(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, 0, installed)
+	require.Equal(t, 1, updated)
+	require.Equal(t, []string{"hand-installed"}, result.Succeeded("update"))
+	require.Len(t, result.Succeeded("create"), 0)
+}
+
+func TestExecutorApplyInDependencyOrder(t *testing.T) {
+	newCmp := func(name string, dependsOn ...string) *Component {
+		cmp := newTestComponent(name)
+		cmp.Namespace = name // the release name is hidden in the install opts; the namespace identifies it instead
+		cmp.SecretValues = SecretValues{}
+		cmp.DependsOn = dependsOn
+		return cmp
+	}
+
+	des := Components{}
+	for _, cmp := range []*Component{newCmp("service", "broker", "database"), newCmp("broker", "database"), newCmp("database")} {
+		des[cmp.Name] = cmp
+	}
+	cur := Components{}
+	for _, cmp := range []*Component{newCmp("old-service", "old-database"), newCmp("old-database")} {
+		cur[cmp.Name] = cmp
+	}
+
+	installed, deleted := []string{}, []string{}
+	helmMock := &HelmclientMock{
+		installRelease: func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			installed = append(installed, namespace)
+			return nil, nil
+		},
+		deleteRelease: func(rlsName string, opts ...helm.DeleteOption) (*services.UninstallReleaseResponse, error) {
+			deleted = append(deleted, rlsName)
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+
+	_, err := NewExecutor(helmMock, chartLoadMock, SecretsProviderMock{}, false, false, waitTimeout, disabledStages).Apply(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, []string{"database", "broker", "service"}, installed)
+	require.Equal(t, []string{"old-service", "old-database"}, deleted)
+}
+
+func TestExecutorApplyUpdatesAfterCreatedDependencies(t *testing.T) {
+	newCmp := func(name string, dependsOn ...string) *Component {
+		cmp := newTestComponent(name)
+		cmp.Namespace = name // the release name is hidden in the install opts; the namespace identifies it instead
+		cmp.SecretValues = SecretValues{}
+		cmp.DependsOn = dependsOn
+		return cmp
+	}
+
+	cur := Components{}
+	for _, cmp := range []*Component{newCmp("service"), newCmp("other")} {
+		cur[cmp.Name] = cmp
+	}
+	des := Components{}
+	for _, cmp := range []*Component{newCmp("service", "database"), newCmp("other"), newCmp("database")} {
+		des[cmp.Name] = cmp
+	}
+	des["other"].Configuration["FlushSize"] = 4
+
+	calls := []string{}
+	helmMock := &HelmclientMock{
+		installRelease: func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			calls = append(calls, "create "+namespace)
+			return nil, nil
+		},
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			calls = append(calls, "update "+rlsName)
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+	secretsMock := SecretsProviderMock{delete: func(componentName, namespace string) error { return nil }}
+	executor := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages)
+
+	// the service gained a dependency on the database, so it is updated once the database is created
+	result, err := executor.Apply(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, []string{"update other", "create database", "update service"}, calls)
+	require.Equal(t, []string{"other", "service"}, result.Succeeded("update"))
+
+	// a component to create can't both come after the creates and before the late updates
+	calls = []string{}
+	des["frontend"] = newCmp("frontend", "service")
+	_, err = executor.Apply(des, cur)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot order `frontend`: it depends on `service`")
+	require.Empty(t, calls)
+}
+
+func TestExecutorApplyInParallel(t *testing.T) {
+	newCmp := func(name string, dependsOn ...string) *Component {
+		cmp := newTestComponent(name)
+		cmp.Namespace = name // the release name is hidden in the install opts; the namespace identifies it instead
+		cmp.SecretValues = SecretValues{}
+		cmp.DependsOn = dependsOn
+		return cmp
+	}
+
+	des := Components{}
+	for _, cmp := range []*Component{newCmp("a"), newCmp("b"), newCmp("c"), newCmp("database"), newCmp("service", "database")} {
+		des[cmp.Name] = cmp
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	finished := map[string]bool{}
+	helmMock := &HelmclientMock{
+		installRelease: func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			if namespace == "service" {
+				assert.True(t, finished["database"])
+			}
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			running--
+			finished[namespace] = true
+			if namespace == "b" {
+				return nil, errors.New("b is broken")
+			}
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+
+	result, err := NewExecutor(helmMock, chartLoadMock, SecretsProviderMock{}, false, false, waitTimeout, disabledStages, WithParallelism(2)).Apply(des, Components{})
+	require.Error(t, err)
+	require.Equal(t, 2, maxRunning)
+
+	errs, ok := err.(ApplyErrors)
+	require.True(t, ok)
+	require.Len(t, errs, 1)
+	require.Equal(t, "b", errs[0].Component)
+	require.Equal(t, "create", errs[0].Stage)
+	require.Contains(t, err.Error(), "b is broken")
+	require.NotContains(t, result.Succeeded("create"), "b")
+}
+
+func TestExecutorApplyStopsWhenInterrupted(t *testing.T) {
+	cur := newTestComponent("old")
+	cur.SecretValues = SecretValues{}
+	des := newTestComponent("new")
+	des.SecretValues = SecretValues{}
+
+	var lost error
+	helmMock := &HelmclientMock{
+		deleteRelease: func(rlsName string, opts ...helm.DeleteOption) (*services.UninstallReleaseResponse, error) {
+			lost = errors.New("landscape lock was taken over by `ci-runner/42`")
+			return nil, nil
+		},
+		installRelease: func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			t.Fatal("no phase may start after the interruption")
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+
+	result, err := NewExecutor(helmMock, chartLoadMock, SecretsProviderMock{}, false, false, waitTimeout, disabledStages, WithInterrupt(func() error { return lost })).Apply(Components{"new": des}, Components{"old": cur})
+	require.Error(t, err)
+	require.Equal(t, lost, err)
+	require.Equal(t, []string{"old"}, result.Succeeded("delete"))
+	require.Empty(t, result.Succeeded("create"))
+}
+
+func TestExecutorApplyReportsUnstartedComponents(t *testing.T) {
+	newCmp := func(name string, dependsOn ...string) *Component {
+		cmp := newTestComponent(name)
+		cmp.Namespace = name // the release name is hidden in the install opts; the namespace identifies it instead
+		cmp.SecretValues = SecretValues{}
+		cmp.DependsOn = dependsOn
+		return cmp
+	}
+
+	des := Components{}
+	for _, cmp := range []*Component{newCmp("a"), newCmp("b"), newCmp("c", "b"), newCmp("d", "b")} {
+		des[cmp.Name] = cmp
+	}
+
+	// in parallel, a fails while b is being created; c and d wait for b
+	var bStarted, aFailed chan struct{}
+	helmMock := &HelmclientMock{
+		installRelease: func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			switch {
+			case namespace == "a":
+				if bStarted != nil {
+					<-bStarted
+					defer close(aFailed)
+				}
+				return nil, errors.New("a is broken")
+			case namespace == "b" && bStarted != nil:
+				close(bStarted)
+				<-aFailed
+				time.Sleep(10 * time.Millisecond) // for the failure of a to be recorded
+			}
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+
+	for _, parallelism := range []int{1, 2} {
+		skipped := []string{"b", "c", "d"}
+		if parallelism > 1 {
+			bStarted, aFailed = make(chan struct{}), make(chan struct{})
+			skipped = []string{"c", "d"}
+		}
+
+		result, err := NewExecutor(helmMock, chartLoadMock, SecretsProviderMock{}, false, false, waitTimeout, disabledStages, WithParallelism(parallelism)).Apply(des, Components{})
+		require.Error(t, err)
+		require.Len(t, result.Components, 4, "every component is accounted for")
+
+		actual := result.Skipped()
+		sort.Strings(actual)
+		require.Equal(t, skipped, actual)
+		for _, cr := range result.Components {
+			if cr.SkippedReason != "" {
+				require.Equal(t, "applying stopped after `a` failed", cr.SkippedReason)
+			}
+		}
+	}
+}
+
+func TestExecutorApplyWithAutoRollback(t *testing.T) {
+	cur := Components{}
+	des := Components{}
+	for _, name := range []string{"up1", "up2"} {
+		cmp := newTestComponent(name)
+		cur[cmp.Name] = cmp
+
+		updiff := newTestComponent(name)
+		updiff.Configuration["FlushSize"] = 4
+		des[updiff.Name] = updiff
+	}
+	des["up2"].DependsOn = []string{"up1"}
+	nu := newTestComponent("new-one")
+	des[nu.Name] = nu
+
+	rolledBack := map[string]bool{}
+	helmMock := &HelmclientMock{
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			if rlsName == "up2" {
+				return nil, errors.New("timed out waiting for the condition")
+			}
+			return nil, nil
+		},
+		releaseHistory: func(rlsName string, opts ...helm.HistoryOption) (*services.GetHistoryResponse, error) {
+			return &services.GetHistoryResponse{Releases: []*release.Release{
+				{Name: rlsName, Version: 3, Info: &release.Info{Status: &release.Status{Code: release.Status_DEPLOYED}}},
+				{Name: rlsName, Version: 2, Info: &release.Info{Status: &release.Status{Code: release.Status_SUPERSEDED}}},
+			}}, nil
+		},
+		rollbackRelease: func(rlsName string, opts ...helm.RollbackOption) (*services.RollbackReleaseResponse, error) {
+			rolledBack[rlsName] = true
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+	secretsWritten := map[string]SecretValues{}
+	secretsMock := SecretsProviderMock{
+		write: func(componentName, namespace string, values SecretValues) error {
+			secretsWritten[componentName] = values
+			return nil
+		},
+		delete: func(componentName, namespace string) error {
+			return nil
+		},
+	}
+
+	// only the failed upgrade is rolled back, and its secrets are restored
+	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages, WithAutoRollback(true, false)).Apply(des, cur)
+	require.Error(t, err)
+	require.Equal(t, []string{"up1"}, result.Succeeded("update"))
+	require.Equal(t, []string{"up2"}, result.Succeeded("rollback"))
+	require.Equal(t, map[string]bool{"up2": true}, rolledBack)
+	require.Equal(t, cur["up2"].SecretValues, secretsWritten["up2"])
+	require.Empty(t, result.Succeeded("create"))
+
+	// every change of the run is rolled back
+	rolledBack = map[string]bool{}
+	result, err = NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages, WithAutoRollback(true, true)).Apply(des, cur)
+	require.Error(t, err)
+	require.Equal(t, []string{"up2", "up1"}, result.Succeeded("rollback"))
+	require.Equal(t, map[string]bool{"up1": true, "up2": true}, rolledBack)
+
+	// without auto rollback nothing is rolled back
+	rolledBack = map[string]bool{}
+	result, err = NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages).Apply(des, cur)
+	require.Error(t, err)
+	require.Empty(t, result.Succeeded("rollback"))
+	require.Empty(t, rolledBack)
+}
+
+func TestExecutorApplyRunsReleaseTests(t *testing.T) {
+	up := newTestComponent("updated-one")
+	updiff := newTestComponent("updated-one")
+	updiff.Configuration["FlushSize"] = 4
+	updiff.Test = true
+	nu := newTestComponent("new-one")
+
+	des := Components{updiff.Name: updiff, nu.Name: nu}
+	cur := Components{up.Name: up}
+
+	testStatus := release.TestRun_SUCCESS
+	tested := []string{}
+	rolledBack := map[string]bool{}
+	helmMock := &HelmclientMock{
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			return &services.UpdateReleaseResponse{Release: &release.Release{Version: 2}}, nil
+		},
+		installRelease: func(chStr, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			return nil, nil
+		},
+		runReleaseTest: func(rlsName string, opts ...helm.ReleaseTestOption) (<-chan *services.TestReleaseResponse, <-chan error) {
+			tested = append(tested, rlsName)
+			responses := make(chan *services.TestReleaseResponse, 2)
+			errc := make(chan error)
+			responses <- &services.TestReleaseResponse{Msg: "RUNNING: " + rlsName + "-test", Status: release.TestRun_RUNNING}
+			responses <- &services.TestReleaseResponse{Msg: "DONE: " + rlsName + "-test", Status: testStatus}
+			close(responses)
+			close(errc)
+			return responses, errc
+		},
+		releaseHistory: func(rlsName string, opts ...helm.HistoryOption) (*services.GetHistoryResponse, error) {
+			return &services.GetHistoryResponse{Releases: []*release.Release{
+				{Name: rlsName, Version: 1, Info: &release.Info{Status: &release.Status{Code: release.Status_DEPLOYED}}},
+			}}, nil
+		},
+		rollbackRelease: func(rlsName string, opts ...helm.RollbackOption) (*services.RollbackReleaseResponse, error) {
+			rolledBack[rlsName] = true
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+	secretsMock := SecretsProviderMock{
+		write: func(componentName, namespace string, values SecretValues) error {
+			return nil
+		},
+		delete: func(componentName, namespace string) error {
+			return nil
+		},
+	}
+
+	// only the component that asks for it is tested
+	_, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages).Apply(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, []string{"updated-one"}, tested)
+
+	// all components are tested
+	tested = []string{}
+	_, err = NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages, WithReleaseTests(true)).Apply(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, []string{"updated-one", "new-one"}, tested)
+
+	// a failing test fails the component and triggers the rollback
+	testStatus = release.TestRun_FAILURE
+	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages, WithAutoRollback(true, false)).Apply(des, cur)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "1 test(s) of release `updated-one` failed")
+	require.Equal(t, "failed", result.Components[0].Status())
+	require.Equal(t, int32(2), result.Components[0].RevisionAfter)
+	require.Equal(t, map[string]bool{"updated-one": true}, rolledBack)
+
+	// tests don't run in dry-run
+	tested = []string{}
+	_, err = NewExecutor(helmMock, chartLoadMock, secretsMock, true, false, waitTimeout, disabledStages, WithReleaseTests(true)).Apply(des, cur)
+	require.NoError(t, err)
+	require.Empty(t, tested)
+}
+
+func TestExecutorApplyContinueOnError(t *testing.T) {
+	newCmp := func(name string, dependsOn ...string) *Component {
+		cmp := newTestComponent(name)
+		cmp.Namespace = name // the release name is hidden in the install opts; the namespace identifies it instead
+		cmp.SecretValues = SecretValues{}
+		cmp.DependsOn = dependsOn
+		return cmp
+	}
+
+	cur := Components{}
+	des := Components{}
+	for _, cmp := range []*Component{newCmp("database"), newCmp("other")} {
+		cur[cmp.Name] = cmp
+		updiff := newCmp(cmp.Name)
+		updiff.Configuration["FlushSize"] = 4
+		des[updiff.Name] = updiff
+	}
+	for _, cmp := range []*Component{newCmp("service", "database"), newCmp("frontend", "service"), newCmp("broken"), newCmp("independent")} {
+		des[cmp.Name] = cmp
+	}
+
+	installed := []string{}
+	helmMock := &HelmclientMock{
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			if rlsName == "database" {
+				return nil, errors.New("UPGRADE FAILED: database is broken")
+			}
+			return nil, nil
+		},
+		installRelease: func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			if namespace == "broken" {
+				return nil, errors.New("release broken failed: chart is broken")
+			}
+			installed = append(installed, namespace)
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+	secretsMock := SecretsProviderMock{
+		delete: func(componentName, namespace string) error {
+			return nil
+		},
+	}
+
+	for _, parallelism := range []int{1, 3} {
+		installed = []string{}
+		result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages, WithContinueOnError(true), WithParallelism(parallelism)).Apply(des, cur)
+		require.Error(t, err)
+
+		errs, ok := err.(ApplyErrors)
+		require.True(t, ok)
+		require.Len(t, errs, 2)
+		require.Equal(t, "database", errs[0].Component)
+		require.Equal(t, "update", errs[0].Stage)
+		require.Equal(t, "broken", errs[1].Component)
+		require.Equal(t, "create", errs[1].Stage)
+		require.Contains(t, err.Error(), "chart is broken")
+
+		require.Equal(t, []string{"other"}, result.Succeeded("update"))
+		require.Equal(t, []string{"independent"}, result.Succeeded("create"))
+		require.Equal(t, []string{"frontend", "service"}, sortedStrings(result.Skipped()))
+		require.Equal(t, []string{"independent"}, installed)
+	}
+
+	// without continuing on errors, the creates aren't even attempted
+	installed = []string{}
+	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages).Apply(des, cur)
+	require.Error(t, err)
+	require.Empty(t, installed)
+	require.Empty(t, result.Succeeded("create"))
+}
+
+func sortedStrings(ss []string) []string {
+	ss = append([]string{}, ss...)
+	sort.Strings(ss)
+	return ss
+}
+
+func TestExecutorApplyResult(t *testing.T) {
+	up := newTestComponent("updated-one")
+	up.Revision = 3
+	updiff := newTestComponent("updated-one")
+	updiff.Configuration["FlushSize"] = 4
+	moved := newTestComponent("moved-one")
+	moved.Revision = 7
+	movediff := newTestComponent("moved-one")
+	movediff.Namespace = "elsewhere"
+
+	des := Components{updiff.Name: updiff, movediff.Name: movediff}
+	cur := Components{up.Name: up, moved.Name: moved}
+
+	helmMock := &HelmclientMock{
+		installRelease: func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			return &services.InstallReleaseResponse{Release: &release.Release{Version: 1}}, nil
+		},
+		deleteRelease: func(rlsName string, opts ...helm.DeleteOption) (*services.UninstallReleaseResponse, error) {
+			return nil, nil
+		},
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			return &services.UpdateReleaseResponse{Release: &release.Release{Version: 4}}, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+	secretsMock := SecretsProviderMock{
+		write: func(componentName, namespace string, values SecretValues) error {
+			return nil
+		},
+		delete: func(componentName, namespace string) error {
+			return nil
+		},
+	}
+
+	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages).Apply(des, cur)
+	require.NoError(t, err)
+	require.Len(t, result.Components, 3)
+
+	del, upd, cre := result.Components[0], result.Components[1], result.Components[2]
+	require.Equal(t, &ComponentResult{Component: "moved-one", Action: "delete", Forced: true, ForcedReason: ForcedByNamespace, RevisionBefore: 7, DurationSeconds: del.DurationSeconds}, del)
+	require.Equal(t, &ComponentResult{Component: "updated-one", Action: "update", RevisionBefore: 3, RevisionAfter: 4, DurationSeconds: upd.DurationSeconds}, upd)
+	require.Equal(t, &ComponentResult{Component: "moved-one", Action: "create", Forced: true, ForcedReason: ForcedByNamespace, RevisionAfter: 1, DurationSeconds: cre.DurationSeconds}, cre)
+}
+
+func TestExecutorApplyDeletionGuard(t *testing.T) {
+	cur := Components{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		cmp := newTestComponent(name)
+		cur[cmp.Name] = cmp
+	}
+	des := Components{"a": newTestComponent("a")}
+
+	deleted := []string{}
+	helmMock := &HelmclientMock{
+		deleteRelease: func(rlsName string, opts ...helm.DeleteOption) (*services.UninstallReleaseResponse, error) {
+			deleted = append(deleted, rlsName)
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+	secretsMock := SecretsProviderMock{
+		delete: func(componentName, namespace string) error {
+			return nil
+		},
+	}
+	apply := func(dryRun bool, guard DeletionGuard) error {
+		deleted = []string{}
+		_, err := NewExecutor(helmMock, chartLoadMock, secretsMock, dryRun, false, waitTimeout, disabledStages, WithDeletionGuard(guard)).Apply(des, cur)
+		return err
+	}
+
+	err := apply(false, DeletionGuard{MaxPercentage: 50})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "refusing to delete 3 of 4 components (b, c, d)")
+	require.Empty(t, deleted)
+
+	require.Error(t, apply(false, DeletionGuard{MaxDeletions: 2}))
+	require.Empty(t, deleted)
+
+	require.NoError(t, apply(false, DeletionGuard{MaxDeletions: 3, MaxPercentage: 75}))
+	require.Len(t, deleted, 3)
+
+	require.NoError(t, apply(false, DeletionGuard{MaxDeletions: 2, Override: true}))
+	require.Len(t, deleted, 3)
+
+	require.NoError(t, apply(true, DeletionGuard{MaxDeletions: 2}))
+
+	var asked []string
+	confirm := func(answer bool) func([]string, int) bool {
+		return func(deletions []string, total int) bool {
+			asked = deletions
+			require.Equal(t, 4, total)
+			return answer
+		}
+	}
+	require.Error(t, apply(false, DeletionGuard{MaxDeletions: 2, Confirm: confirm(false)}))
+	require.Equal(t, []string{"b", "c", "d"}, asked)
+	require.Empty(t, deleted)
+	require.NoError(t, apply(false, DeletionGuard{MaxDeletions: 2, Confirm: confirm(true)}))
+	require.Len(t, deleted, 3)
 }
 
 func TestExecutorCreate(t *testing.T) {
@@ -322,9 +1160,9 @@ func TestExecutorApplyWithDisabledStages(t *testing.T) {
 
 	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, []string{"delete", "create", "update"}).Apply(des, cur)
 	require.NoError(t, err)
-	require.Equal(t, len(result["create"]), 0)
-	require.Equal(t, len(result["update"]), 0)
-	require.Equal(t, len(result["delete"]), 0)
+	require.Equal(t, len(result.Succeeded("create")), 0)
+	require.Equal(t, len(result.Succeeded("update")), 0)
+	require.Equal(t, len(result.Succeeded("delete")), 0)
 }
 
 func newTestComponent(name string) *Component {
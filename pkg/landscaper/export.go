@@ -0,0 +1,192 @@
+package landscaper
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// Exporter reconstructs landscape component files from the actual state
+type Exporter interface {
+	// Export returns the content of a component file per release, keyed by file name
+	Export() (map[string][]byte, error)
+}
+
+type helmExporter struct {
+	state            *helmStateProvider
+	namespace        string
+	includeUnmanaged bool
+	chartRepository  string
+}
+
+// componentFile is the on-disk format of a Component
+type componentFile struct {
+	Name          string            `json:"name"`
+	Namespace     string            `json:"namespace,omitempty"`
+	Release       *Release          `json:"release"`
+	Configuration Configuration     `json:"configuration,omitempty"`
+	Secrets       []string          `json:"secrets,omitempty"`
+	DependsOn     []string          `json:"dependsOn,omitempty"`
+	Protect       bool              `json:"protect,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+// NewHelmExporter creates an Exporter for the releases in Helm. Components in namespace don't get an explicit namespace.
+// When includeUnmanaged is set, releases not controlled by landscaper are exported too, with their chart attributed to chartRepository.
+func NewHelmExporter(helmClient helm.Interface, secrets SecretsReader, releaseNamePrefix, namespace string, includeUnmanaged bool, chartRepository string) Exporter {
+	return &helmExporter{
+		state:            &helmStateProvider{helmClient, secrets, releaseNamePrefix},
+		namespace:        namespace,
+		includeUnmanaged: includeUnmanaged,
+		chartRepository:  chartRepository,
+	}
+}
+
+// Export writes a component file for each release, holding only the values that differ from the chart defaults
+func (e *helmExporter) Export() (map[string][]byte, error) {
+	// unmanaged releases needn't have the prefix, so their files could only name them after the release as it is; but
+	// applying them would prefix that name again and install a second release
+	if e.includeUnmanaged && e.state.releaseNamePrefix != "" {
+		return nil, fmt.Errorf("releases not controlled by landscaper can only be exported without a release name prefix, not with `%s`; use --no-prefix, and apply the files with --no-prefix too", e.state.releaseNamePrefix)
+	}
+
+	files := map[string][]byte{}
+	exported := map[string]string{} // the release exported to each file, by lowercased file name
+
+	helmReleases, err := e.state.listHelmReleases()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, release := range helmReleases {
+		managed := true
+		cmp, err := newComponentFromHelmRelease(release)
+		if err == ErrNonLandscapeComponent {
+			if !e.includeUnmanaged {
+				logrus.WithFields(logrus.Fields{"release": release.Name}).Debug("Skipping release not controlled by landscaper")
+				continue
+			}
+			managed = false
+			cmp, err = newUnmanagedComponentFromHelmRelease(release, e.chartRepository)
+		} else if err == nil && !strings.HasPrefix(release.Name, e.state.releaseNamePrefix) {
+			logrus.WithFields(logrus.Fields{"release": release.Name}).Debug("Skipping release of another landscape")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		cf, err := e.componentFile(cmp, release, managed)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := yaml.Marshal(cf)
+		if err != nil {
+			return nil, err
+		}
+
+		file := cf.Name + ".yaml"
+		if other, ok := exported[strings.ToLower(file)]; ok {
+			return nil, fmt.Errorf("cannot export releases `%s` and `%s`: both have component name `%s`", other, release.Name, cf.Name)
+		}
+		exported[strings.ToLower(file)] = release.Name
+		files[file] = content
+	}
+
+	logrus.WithFields(logrus.Fields{"totalReleases": len(helmReleases), "exportedComponents": len(files)}).Info("Exported Releases (Components)")
+
+	return files, nil
+}
+
+// componentFile turns a Component reconstructed from release back into the file it could have originated from. The
+// values that landscaper adds are only removed from the configuration of a release it manages; in other releases they
+// are chart values.
+func (e *helmExporter) componentFile(cmp *Component, release *release.Release, managed bool) (*componentFile, error) {
+	chartRef, err := cmp.FullChartRef()
+	if err != nil {
+		return nil, err
+	}
+
+	cf := &componentFile{
+		Name:    strings.TrimPrefix(release.Name, e.state.releaseNamePrefix),
+		Release: &Release{Chart: chartRef, Version: cmp.Release.Version},
+	}
+	if name, ok := cmp.Configuration["Name"].(string); ok && managed {
+		cf.Name = name // the name as it was before prefixing and lowercasing
+	}
+	// the name ends up in a file name, and in a release name again when the file is applied
+	if errs := validation.IsDNS1123Label(strings.ToLower(cf.Name)); len(errs) > 0 {
+		return nil, fmt.Errorf("cannot export release `%s`: component name `%s` is invalid: %s", release.Name, cf.Name, strings.Join(errs, "; "))
+	}
+	if cmp.Namespace != e.namespace {
+		cf.Namespace = cmp.Namespace
+	}
+	cf.Protect = cmp.Protect
+	cf.Labels = cmp.Labels
+	for _, dep := range cmp.DependsOn {
+		cf.DependsOn = append(cf.DependsOn, strings.TrimPrefix(dep, e.state.releaseNamePrefix))
+	}
+
+	if _, ok := cmp.Configuration["secretsRef"]; ok && managed {
+		secretValues, err := e.state.secrets.Read(cmp.Name, cmp.Namespace, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key := range secretValues {
+			cf.Secrets = append(cf.Secrets, key)
+		}
+		sort.Strings(cf.Secrets)
+	}
+
+	defaults, err := chartutil.CoalesceValues(release.Chart, &chart.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	cf.Configuration = pruneDefaults(cmp.Configuration, Configuration(defaults))
+	if managed {
+		for _, key := range internalKeys {
+			delete(cf.Configuration, key)
+		}
+	} else {
+		delete(cf.Configuration, metadataKey) // added to attribute the chart
+	}
+
+	return cf, nil
+}
+
+// pruneDefaults returns a copy of cfg without the values that are equal to those in defaults
+func pruneDefaults(cfg, defaults Configuration) Configuration {
+	pruned := Configuration{}
+	for k, v := range cfg {
+		dv, ok := defaults[k]
+		if !ok {
+			pruned[k] = v
+			continue
+		}
+
+		vMap, vIsMap := v.(map[string]interface{})
+		dvMap, dvIsMap := dv.(map[string]interface{})
+		if vIsMap && dvIsMap {
+			if sub := pruneDefaults(vMap, dvMap); len(sub) > 0 {
+				pruned[k] = map[string]interface{}(sub)
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(v, dv) {
+			pruned[k] = v
+		}
+	}
+	return pruned
+}
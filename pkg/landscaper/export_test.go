@@ -0,0 +1,158 @@
+package landscaper
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/stretchr/testify/require"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+func TestHelmExporterExport(t *testing.T) {
+	testChart := &chart.Chart{
+		Metadata: &chart.Metadata{
+			Name:    "chart-name",
+			Version: "1.3.37",
+		},
+		Values: &chart.Config{Raw: `
+config_a: xxx
+config_b: yyy
+nested:
+  keep: default
+  change: default
+`},
+	}
+
+	helmMock := &HelmclientMock{
+		listReleases: func(opts ...helm.ReleaseListOption) (*services.ListReleasesResponse, error) {
+			return &services.ListReleasesResponse{
+				Releases: []*release.Release{
+					{
+						Name:      "pfx-managed",
+						Namespace: "other-namespace",
+						Chart:     testChart,
+						Config: &chart.Config{Raw: fmt.Sprintf(
+							`%s:
+  %s: 1.2.3
+  %s: repo1
+Name: Managed
+secretsRef: pfx-managed
+config_a: xxx
+config_b: zzz
+nested:
+  keep: default
+  change: changed
+`, metadataKey, metaReleaseVersion, metaChartRepo)},
+					},
+					{
+						Name:      "pfx-unmanaged",
+						Namespace: "pfx",
+						Chart:     testChart,
+						Config:    &chart.Config{Raw: "config_c: qqq\nName: chart value\nsecretsRef: also a chart value\n"},
+					},
+					{
+						Name:      "hand-made",
+						Namespace: "pfx",
+						Chart:     testChart,
+						Config:    &chart.Config{Raw: "config_a: xxx\n"},
+					},
+					{
+						Name:      "other-landscape",
+						Namespace: "pfx",
+						Chart:     testChart,
+						Config:    &chart.Config{Raw: fmt.Sprintf("%s:\n  %s: 1.0.0\n  %s: repo1\nName: OtherLandscape\n", metadataKey, metaReleaseVersion, metaChartRepo)},
+					},
+				},
+			}, nil
+		},
+	}
+	secretsMock := SecretsProviderMock{
+		read: func(componentName, namespace string, secretNames SecretNames) (SecretValues, error) {
+			require.Equal(t, "pfx-managed", componentName)
+			return SecretValues{"password": []byte("s3cr3t"), "api-key": []byte("k3y")}, nil
+		},
+	}
+
+	files, err := NewHelmExporter(helmMock, secretsMock, "pfx-", "pfx", false, "stable").Export()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Contains(t, files, "Managed.yaml")
+
+	cf := &componentFile{}
+	require.NoError(t, yaml.Unmarshal(files["Managed.yaml"], cf))
+	require.Equal(t, &componentFile{
+		Name:      "Managed",
+		Namespace: "other-namespace",
+		Release:   &Release{Chart: "repo1/chart-name:1.3.37", Version: "1.2.3"},
+		Configuration: Configuration{
+			"config_b": "zzz",
+			"nested":   map[string]interface{}{"change": "changed"},
+		},
+		Secrets: []string{"api-key", "password"},
+	}, cf)
+	require.NotContains(t, string(files["Managed.yaml"]), "s3cr3t")
+
+	// releases that needn't have the prefix can't be exported with one, since applying them would add it
+	_, err = NewHelmExporter(helmMock, secretsMock, "pfx-", "pfx", true, "stable").Export()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "use --no-prefix")
+
+	// without a prefix all releases are exported, under their own name
+	files, err = NewHelmExporter(helmMock, secretsMock, "", "pfx", true, "stable").Export()
+	require.NoError(t, err)
+	require.Len(t, files, 4)
+	require.Contains(t, files, "Managed.yaml")
+	require.Contains(t, files, "pfx-unmanaged.yaml")
+	require.Contains(t, files, "hand-made.yaml")
+	require.Contains(t, files, "OtherLandscape.yaml")
+
+	// the values of unmanaged releases are their own, even where they have the names of those landscaper adds
+	cf = &componentFile{}
+	require.NoError(t, yaml.Unmarshal(files["pfx-unmanaged.yaml"], cf))
+	require.Equal(t, &componentFile{
+		Name:          "pfx-unmanaged",
+		Release:       &Release{Chart: "stable/chart-name:1.3.37"},
+		Configuration: Configuration{"config_c": "qqq", "Name": "chart value", "secretsRef": "also a chart value"},
+	}, cf)
+}
+
+func TestHelmExporterRejectsInvalidNames(t *testing.T) {
+	helmMock := &HelmclientMock{
+		listReleases: func(opts ...helm.ReleaseListOption) (*services.ListReleasesResponse, error) {
+			return &services.ListReleasesResponse{Releases: []*release.Release{{
+				Name:      "pfx-managed",
+				Namespace: "pfx",
+				Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "chart-name", Version: "1.3.37"}},
+				Config:    &chart.Config{Raw: fmt.Sprintf("%s:\n  %s: 1.0.0\n  %s: repo1\nName: ../../etc/cron.d/x\n", metadataKey, metaReleaseVersion, metaChartRepo)},
+			}}}, nil
+		},
+	}
+
+	_, err := NewHelmExporter(helmMock, SecretsProviderMock{}, "pfx-", "pfx", false, "stable").Export()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot export release `pfx-managed`: component name `../../etc/cron.d/x` is invalid")
+}
+
+func TestHelmExporterRejectsDuplicateNames(t *testing.T) {
+	managed := func(name, componentName string) *release.Release {
+		return &release.Release{
+			Name:      name,
+			Namespace: "pfx",
+			Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "chart-name", Version: "1.3.37"}},
+			Config:    &chart.Config{Raw: fmt.Sprintf("%s:\n  %s: 1.0.0\n  %s: repo1\nName: %s\n", metadataKey, metaReleaseVersion, metaChartRepo, componentName)},
+		}
+	}
+	helmMock := &HelmclientMock{
+		listReleases: func(opts ...helm.ReleaseListOption) (*services.ListReleasesResponse, error) {
+			return &services.ListReleasesResponse{Releases: []*release.Release{managed("pfx-web", "Web"), managed("pfx-web-copy", "web")}}, nil
+		},
+	}
+
+	_, err := NewHelmExporter(helmMock, SecretsProviderMock{}, "pfx-", "pfx", false, "stable").Export()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "cannot export releases `pfx-web` and `pfx-web-copy`: both have component name `web`")
+}
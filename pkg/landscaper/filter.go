@@ -0,0 +1,127 @@
+package landscaper
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ComponentFilter selects components by name and by labels, e.g. to apply only part of a landscape.
+// Names are matched without the release name prefix, in lower case.
+type ComponentFilter struct {
+	only              []string // glob patterns of which a name must match one; none matches all
+	skip              []string // glob patterns of which a name must match none
+	selector          labels.Selector
+	releaseNamePrefix string
+}
+
+// NewComponentFilter creates a ComponentFilter from glob patterns for the names to select and to skip, and a Kubernetes
+// label selector such as `team=payments`. Empty patterns and selector select everything.
+func NewComponentFilter(only, skip []string, selector, releaseNamePrefix string) (*ComponentFilter, error) {
+	for _, pattern := range append(append([]string{}, only...), skip...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bad component name pattern `%s`: %s", pattern, err)
+		}
+	}
+
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("bad label selector `%s`: %s", selector, err)
+	}
+
+	return &ComponentFilter{only: lowered(only), skip: lowered(skip), selector: sel, releaseNamePrefix: releaseNamePrefix}, nil
+}
+
+// Empty tells whether the filter selects everything
+func (f *ComponentFilter) Empty() bool {
+	return f == nil || (len(f.only) == 0 && len(f.skip) == 0 && f.selector.Empty())
+}
+
+// String describes the filter, e.g. to make sure a plan is applied with the filter it was made with
+func (f *ComponentFilter) String() string {
+	if f.Empty() {
+		return ""
+	}
+
+	parts := []string{}
+	if len(f.only) > 0 {
+		parts = append(parts, "only="+strings.Join(f.only, ","))
+	}
+	if len(f.skip) > 0 {
+		parts = append(parts, "skip="+strings.Join(f.skip, ","))
+	}
+	if !f.selector.Empty() {
+		parts = append(parts, "selector="+f.selector.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// Matches tells whether cmp is selected
+func (f *ComponentFilter) Matches(cmp *Component) bool {
+	if f.Empty() {
+		return true
+	}
+
+	name := strings.TrimPrefix(cmp.Name, f.releaseNamePrefix)
+	if len(f.only) > 0 && !matchesAny(f.only, name) {
+		return false
+	}
+	if matchesAny(f.skip, name) {
+		return false
+	}
+	return f.selector.Matches(labels.Set(cmp.Labels))
+}
+
+// SelectStates returns the desired and the current components that are selected. Selection is worked out once per
+// component name: a component is selected when either its desired or its current state matches. Otherwise a component
+// whose labels changed would be deleted, or created while its release still exists.
+func (f *ComponentFilter) SelectStates(desired, current Components) (Components, Components) {
+	if f.Empty() {
+		return desired, current
+	}
+
+	names := map[string]bool{}
+	for _, cs := range []Components{desired, current} {
+		for name, cmp := range cs {
+			if f.Matches(cmp) {
+				names[name] = true
+			}
+		}
+	}
+
+	selectedDesired, selectedCurrent := selectNames(desired, names), selectNames(current, names)
+	logrus.WithFields(logrus.Fields{"filter": f.String(), "selected": len(names), "desired": len(desired), "current": len(current)}).Info("Selected components")
+	return selectedDesired, selectedCurrent
+}
+
+// selectNames returns the components of cs whose names are in names
+func selectNames(cs Components, names map[string]bool) Components {
+	selected := Components{}
+	for name, cmp := range cs {
+		if names[name] {
+			selected[name] = cmp
+		}
+	}
+	return selected
+}
+
+// matchesAny tells whether name matches any of the glob patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func lowered(ss []string) []string {
+	var lower []string
+	for _, s := range ss {
+		lower = append(lower, strings.ToLower(s))
+	}
+	return lower
+}
@@ -0,0 +1,94 @@
+package landscaper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComponentFilter(t *testing.T) {
+	newLabeled := func(name string, labels map[string]string) *Component {
+		cfg := Configuration{}
+		cfg.SetMetadata(&Metadata{ReleaseVersion: "1.0.0", Labels: labels})
+		return NewComponent(name, "ns", &Release{Chart: "chart:1.0.0", Version: "1.0.0"}, cfg, nil, nil)
+	}
+	cs := Components{}
+	for _, cmp := range []*Component{
+		newLabeled("pfx-payments-api", map[string]string{"team": "payments"}),
+		newLabeled("pfx-payments-db", map[string]string{"team": "payments", "tier": "data"}),
+		newLabeled("pfx-search-api", map[string]string{"team": "search"}),
+		newLabeled("pfx-ingress", nil),
+	} {
+		cs[cmp.Name] = cmp
+	}
+	require.Equal(t, map[string]string{"team": "search"}, cs["pfx-search-api"].Labels)
+
+	selected := func(only, skip []string, selector string) []string {
+		f, err := NewComponentFilter(only, skip, selector, "pfx-")
+		require.NoError(t, err)
+		desired, current := f.SelectStates(cs, cs)
+		require.Equal(t, desired.names(), current.names())
+		return desired.names()
+	}
+
+	require.Equal(t, cs.names(), selected(nil, nil, ""))
+	require.Equal(t, []string{"pfx-payments-api", "pfx-payments-db"}, selected([]string{"Payments-*"}, nil, ""))
+	require.Equal(t, []string{"pfx-payments-api", "pfx-search-api"}, selected([]string{"*-api"}, nil, ""))
+	require.Equal(t, []string{"pfx-ingress", "pfx-payments-api", "pfx-search-api"}, selected(nil, []string{"*-db"}, ""))
+	require.Equal(t, []string{"pfx-payments-api", "pfx-payments-db"}, selected(nil, nil, "team=payments"))
+	require.Equal(t, []string{"pfx-payments-api"}, selected(nil, nil, "team=payments,tier!=data"))
+	require.Equal(t, []string{"pfx-payments-db"}, selected([]string{"payments-*"}, []string{"*-api"}, "tier"))
+
+	_, err := NewComponentFilter([]string{"[a-"}, nil, "", "pfx-")
+	require.Error(t, err)
+	_, err = NewComponentFilter(nil, nil, "team in payments", "pfx-")
+	require.Error(t, err)
+
+	f, err := NewComponentFilter([]string{"a*"}, []string{"ab"}, "team=x", "pfx-")
+	require.NoError(t, err)
+	require.Equal(t, "only=a* skip=ab selector=team=x", f.String())
+	f, err = NewComponentFilter(nil, nil, "", "pfx-")
+	require.NoError(t, err)
+	require.True(t, f.Empty())
+	require.Equal(t, "", f.String())
+}
+
+func TestComponentFilterSelectStates(t *testing.T) {
+	newLabeled := func(name string, labels map[string]string) *Component {
+		cfg := Configuration{}
+		cfg.SetMetadata(&Metadata{ReleaseVersion: "1.0.0", Labels: labels})
+		return NewComponent(name, "ns", &Release{Chart: "chart:1.0.0", Version: "1.0.0"}, cfg, nil, nil)
+	}
+	f, err := NewComponentFilter(nil, nil, "team=payments", "pfx-")
+	require.NoError(t, err)
+
+	desired := Components{}
+	current := Components{}
+	for _, cmp := range []*Component{
+		newLabeled("pfx-moved", map[string]string{"team": "search"}),     // labels changed in the files
+		newLabeled("pfx-labeled", map[string]string{"team": "payments"}), // labels added in the files
+		newLabeled("pfx-new", map[string]string{"team": "payments"}),
+		newLabeled("pfx-other", map[string]string{"team": "search"}),
+	} {
+		desired[cmp.Name] = cmp
+	}
+	for _, cmp := range []*Component{
+		newLabeled("pfx-moved", map[string]string{"team": "payments"}),
+		newLabeled("pfx-labeled", nil), // a release from before labels
+		newLabeled("pfx-gone", map[string]string{"team": "payments"}),
+		newLabeled("pfx-other", map[string]string{"team": "search"}),
+	} {
+		current[cmp.Name] = cmp
+	}
+
+	selDesired, selCurrent := f.SelectStates(desired, current)
+	require.Equal(t, []string{"pfx-labeled", "pfx-moved", "pfx-new"}, selDesired.names())
+	require.Equal(t, []string{"pfx-gone", "pfx-labeled", "pfx-moved"}, selCurrent.names())
+
+	// a plan applies to the current components it selected, and to those that match since
+	p := &Plan{}
+	p.SetSelection(f, selCurrent)
+	require.Equal(t, "selector=team=payments", p.Selection)
+	current["pfx-late"] = newLabeled("pfx-late", map[string]string{"team": "payments"})
+	require.Equal(t, []string{"pfx-gone", "pfx-labeled", "pfx-late", "pfx-moved"}, p.SelectCurrent(current, f).names())
+}
@@ -0,0 +1,302 @@
+package landscaper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	"k8s.io/kubernetes/pkg/apis/core"
+	batchinternal "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/batch/internalversion"
+)
+
+// defaultHookTimeout is how long a hook may take when it doesn't specify a timeout
+const defaultHookTimeout = 10 * time.Minute
+
+// hookPollInterval is how often the status of a Job hook is checked
+var hookPollInterval = 2 * time.Second
+
+// Hooks are run before (Pre) and after (Post) the create, update or delete of a component
+type Hooks struct {
+	Pre  []*Hook `json:"pre,omitempty"`
+	Post []*Hook `json:"post,omitempty"`
+}
+
+// Hook is a local command or a Kubernetes Job. It gets the component name, namespace, action and release revision in
+// its environment, as LANDSCAPER_COMPONENT, LANDSCAPER_NAMESPACE, LANDSCAPER_ACTION and LANDSCAPER_REVISION.
+type Hook struct {
+	Name    string   `json:"name,omitempty"`
+	Actions []string `json:"actions,omitempty"` // the actions to run the hook for: create, update and/or delete; none means all
+	Command []string `json:"command,omitempty"` // a local command and its arguments
+	Job     *JobHook `json:"job,omitempty"`     // a Kubernetes Job
+	Timeout string   `json:"timeout,omitempty"` // how long the hook may take, e.g. 5m; defaults to 10m
+}
+
+// JobHook describes a Kubernetes Job with a single container
+type JobHook struct {
+	Image     string   `json:"image"`
+	Command   []string `json:"command,omitempty"`
+	Args      []string `json:"args,omitempty"`
+	Namespace string   `json:"namespace,omitempty"` // defaults to the namespace of the component
+}
+
+// HookEnv is what a hook gets to know about the component it runs for
+type HookEnv struct {
+	Component string
+	Namespace string
+	Action    string // create, update or delete
+	Revision  int32  // the release revision before the action for pre hooks, and after the action for post hooks; 0 if there is none
+}
+
+// HookRunner runs hooks
+type HookRunner interface {
+	Run(hook *Hook, env HookEnv, log logrus.FieldLogger) error
+}
+
+type hookRunner struct {
+	jobs batchinternal.JobsGetter
+}
+
+// NewHookRunner creates a HookRunner that runs local commands, and Jobs with jobs. Without jobs, Job hooks fail.
+func NewHookRunner(jobs batchinternal.JobsGetter) HookRunner {
+	return &hookRunner{jobs}
+}
+
+// ReadHooksFile reads landscape-level hooks, that run for every component, from a YAML file
+func ReadHooksFile(fileName string) (*Hooks, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := &Hooks{}
+	if err := yaml.Unmarshal(content, hooks); err != nil {
+		return nil, fmt.Errorf("failed to read hooks file `%s`: %s", fileName, err)
+	}
+	if err := hooks.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid hooks file `%s`: %s", fileName, err)
+	}
+	return hooks, nil
+}
+
+// Validate makes sure every hook is either a command or a Job, for known actions, with a valid timeout
+func (hs *Hooks) Validate() error {
+	if hs == nil {
+		return nil
+	}
+
+	for _, hook := range append(append([]*Hook{}, hs.Pre...), hs.Post...) {
+		if (len(hook.Command) > 0) == (hook.Job != nil) {
+			return fmt.Errorf("hook `%s` must have either a command or a job", hook.String())
+		}
+		if hook.Job != nil && hook.Job.Image == "" {
+			return fmt.Errorf("job hook `%s` has no image", hook.String())
+		}
+		for _, action := range hook.Actions {
+			if action != "create" && action != "update" && action != "delete" {
+				return fmt.Errorf("hook `%s` has unknown action `%s`; expecting create, update or delete", hook.String(), action)
+			}
+		}
+		if _, err := hook.timeout(); err != nil {
+			return fmt.Errorf("hook `%s` has a bad timeout: %s", hook.String(), err)
+		}
+	}
+	return nil
+}
+
+// forAction returns the pre or post hooks that apply to action
+func (hs *Hooks) forAction(when, action string) []*Hook {
+	if hs == nil {
+		return nil
+	}
+
+	hooks := hs.Pre
+	if when == "post" {
+		hooks = hs.Post
+	}
+
+	selected := []*Hook{}
+	for _, hook := range hooks {
+		if len(hook.Actions) == 0 || contains(hook.Actions, action) {
+			selected = append(selected, hook)
+		}
+	}
+	return selected
+}
+
+// forDelete returns the hooks that run when the component is deleted, or nil if there are none
+func (hs *Hooks) forDelete() *Hooks {
+	pre, post := hs.forAction("pre", "delete"), hs.forAction("post", "delete")
+	if len(pre) == 0 && len(post) == 0 {
+		return nil
+	}
+	return &Hooks{Pre: pre, Post: post}
+}
+
+// String names the hook, by its name or else by what it runs
+func (h *Hook) String() string {
+	switch {
+	case h.Name != "":
+		return h.Name
+	case len(h.Command) > 0:
+		return strings.Join(h.Command, " ")
+	case h.Job != nil:
+		return h.Job.Image
+	}
+	return "<empty>"
+}
+
+func (h *Hook) timeout() (time.Duration, error) {
+	if h.Timeout == "" {
+		return defaultHookTimeout, nil
+	}
+	return time.ParseDuration(h.Timeout)
+}
+
+// vars returns the environment variables that describe env
+func (env HookEnv) vars() map[string]string {
+	return map[string]string{
+		"LANDSCAPER_COMPONENT": env.Component,
+		"LANDSCAPER_NAMESPACE": env.Namespace,
+		"LANDSCAPER_ACTION":    env.Action,
+		"LANDSCAPER_REVISION":  fmt.Sprint(env.Revision),
+	}
+}
+
+// Run runs the hook and waits for it to finish; it fails when the hook fails or times out
+func (r *hookRunner) Run(hook *Hook, env HookEnv, log logrus.FieldLogger) error {
+	timeout, err := hook.timeout()
+	if err != nil {
+		return err
+	}
+
+	if hook.Job != nil {
+		return r.runJob(hook, env, timeout, log)
+	}
+	return runCommand(hook, env, timeout, log)
+}
+
+// runCommand runs the hook's command locally, logging its output
+func runCommand(hook *Hook, env HookEnv, timeout time.Duration, log logrus.FieldLogger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = os.Environ()
+	for k, v := range env.vars() {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	out, err := cmd.CombinedOutput()
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		log.WithFields(logrus.Fields{"hook": hook.String()}).Info(scanner.Text())
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("hook `%s` timed out after %s", hook.String(), timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("hook `%s` failed: %s", hook.String(), err)
+	}
+	return nil
+}
+
+// runJob creates a Job for the hook and waits for it to complete. Completed Jobs are deleted; failed ones are kept
+// for inspection.
+func (r *hookRunner) runJob(hook *Hook, env HookEnv, timeout time.Duration, log logrus.FieldLogger) error {
+	if r.jobs == nil {
+		return fmt.Errorf("hook `%s` can't run: no Kubernetes client for jobs", hook.String())
+	}
+
+	namespace := hook.Job.Namespace
+	if namespace == "" {
+		namespace = env.Namespace
+	}
+
+	vars := []core.EnvVar{}
+	for k, v := range env.vars() {
+		vars = append(vars, core.EnvVar{Name: k, Value: v})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+
+	backoffLimit := int32(0)
+	deadline := int64(timeout / time.Second)
+	job, err := r.jobs.Jobs(namespace).Create(&batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s-%s-", truncate(env.Component, 40), env.Action),
+			Namespace:    namespace,
+			Labels:       map[string]string{"app": "landscaper", "landscaper-component": env.Component},
+		},
+		Spec: batch.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: core.PodTemplateSpec{
+				Spec: core.PodSpec{
+					RestartPolicy: core.RestartPolicyNever,
+					Containers: []core.Container{{
+						Name:    "hook",
+						Image:   hook.Job.Image,
+						Command: hook.Job.Command,
+						Args:    hook.Job.Args,
+						Env:     vars,
+					}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating job for hook `%s` failed: %s", hook.String(), err)
+	}
+
+	log.WithFields(logrus.Fields{"hook": hook.String(), "job": job.Name, "namespace": namespace}).Info("Started hook job")
+
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(hookPollInterval) {
+		job, err = r.jobs.Jobs(namespace).Get(job.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		for _, c := range job.Status.Conditions {
+			if c.Status != core.ConditionTrue {
+				continue
+			}
+			switch c.Type {
+			case batch.JobComplete:
+				log.WithFields(logrus.Fields{"hook": hook.String(), "job": job.Name}).Info("Hook job completed")
+				propagation := metav1.DeletePropagationBackground
+				return r.jobs.Jobs(namespace).Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+			case batch.JobFailed:
+				return fmt.Errorf("hook job `%s` in namespace `%s` failed: %s", job.Name, namespace, c.Message)
+			}
+		}
+	}
+
+	return fmt.Errorf("hook job `%s` in namespace `%s` timed out after %s", job.Name, namespace, timeout)
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
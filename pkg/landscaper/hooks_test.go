@@ -0,0 +1,224 @@
+package landscaper
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+type hookRunnerMock func(hook *Hook, env HookEnv) error
+
+func (m hookRunnerMock) Run(hook *Hook, env HookEnv, log logrus.FieldLogger) error {
+	return m(hook, env)
+}
+
+func TestHooksValidate(t *testing.T) {
+	require.NoError(t, (*Hooks)(nil).Validate())
+	require.NoError(t, (&Hooks{Pre: []*Hook{{Command: []string{"true"}, Actions: []string{"update"}, Timeout: "5m"}}, Post: []*Hook{{Job: &JobHook{Image: "smoke:1"}}}}).Validate())
+
+	require.Error(t, (&Hooks{Pre: []*Hook{{}}}).Validate())
+	require.Error(t, (&Hooks{Pre: []*Hook{{Command: []string{"true"}, Job: &JobHook{Image: "smoke:1"}}}}).Validate())
+	require.Error(t, (&Hooks{Post: []*Hook{{Job: &JobHook{}}}}).Validate())
+	require.Error(t, (&Hooks{Pre: []*Hook{{Command: []string{"true"}, Actions: []string{"upgrade"}}}}).Validate())
+	require.Error(t, (&Hooks{Pre: []*Hook{{Command: []string{"true"}, Timeout: "soon"}}}).Validate())
+}
+
+func TestHookRunnerCommand(t *testing.T) {
+	runner := NewHookRunner(nil)
+	env := HookEnv{Component: "pfx-db", Namespace: "ns", Action: "update", Revision: 3}
+
+	check := &Hook{Command: []string{"sh", "-c", `test "$LANDSCAPER_COMPONENT/$LANDSCAPER_NAMESPACE/$LANDSCAPER_ACTION/$LANDSCAPER_REVISION" = pfx-db/ns/update/3`}}
+	require.NoError(t, runner.Run(check, env, logrus.StandardLogger()))
+
+	err := runner.Run(&Hook{Name: "migrate", Command: []string{"sh", "-c", "exit 3"}}, env, logrus.StandardLogger())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "hook `migrate` failed")
+
+	err = runner.Run(&Hook{Name: "slow", Command: []string{"sleep", "5"}, Timeout: "10ms"}, env, logrus.StandardLogger())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out")
+}
+
+func TestHookRunnerJob(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var created *batch.Job
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		created = action.(k8stesting.CreateAction).GetObject().(*batch.Job)
+		created.Name = created.GenerateName + "x1y2z"
+		return true, created, nil
+	})
+	var condition batch.JobConditionType
+	clientset.PrependReactor("get", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := created.DeepCopy()
+		job.Status.Conditions = []batch.JobCondition{{Type: condition, Status: core.ConditionTrue, Message: "BackoffLimitExceeded"}}
+		return true, job, nil
+	})
+	var deleted string
+	clientset.PrependReactor("delete", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deleted = action.(k8stesting.DeleteAction).GetName()
+		return true, nil, nil
+	})
+
+	runner := NewHookRunner(clientset.Batch())
+	env := HookEnv{Component: "pfx-db", Namespace: "ns", Action: "create"}
+
+	condition = batch.JobComplete
+	require.NoError(t, runner.Run(&Hook{Job: &JobHook{Image: "migrate:1", Args: []string{"up"}}}, env, logrus.StandardLogger()))
+	require.Equal(t, "ns", created.Namespace)
+	require.Equal(t, "pfx-db-create-", created.GenerateName)
+	container := created.Spec.Template.Spec.Containers[0]
+	require.Equal(t, "migrate:1", container.Image)
+	require.Equal(t, []string{"up"}, container.Args)
+	require.Contains(t, container.Env, core.EnvVar{Name: "LANDSCAPER_ACTION", Value: "create"})
+	require.Equal(t, "pfx-db-create-x1y2z", deleted)
+
+	condition, deleted = batch.JobFailed, ""
+	err := runner.Run(&Hook{Job: &JobHook{Image: "migrate:1", Namespace: "jobs"}}, env, logrus.StandardLogger())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed: BackoffLimitExceeded")
+	require.Equal(t, "jobs", created.Namespace)
+	require.Equal(t, "", deleted) // failed jobs are kept for inspection
+}
+
+func TestExecutorApplyRunsHooks(t *testing.T) {
+	up := newTestComponent("updated-one")
+	up.Revision = 4
+	updiff := newTestComponent("updated-one")
+	updiff.Configuration["FlushSize"] = 4
+	updiff.Hooks = &Hooks{
+		Pre:  []*Hook{{Name: "migrate", Command: []string{"migrate"}, Actions: []string{"update"}}},
+		Post: []*Hook{{Name: "smoke", Command: []string{"smoke"}}},
+	}
+	nu := newTestComponent("new-one")
+
+	des := Components{updiff.Name: updiff, nu.Name: nu}
+	cur := Components{up.Name: up}
+
+	updated := 0
+	helmMock := &HelmclientMock{
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			updated++
+			return nil, nil
+		},
+		installRelease: func(chStr, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+	secretsMock := SecretsProviderMock{
+		write: func(componentName, namespace string, values SecretValues) error {
+			return nil
+		},
+		delete: func(componentName, namespace string) error {
+			return nil
+		},
+	}
+	landscapeHooks := &Hooks{Post: []*Hook{{Name: "notify", Command: []string{"notify"}}}}
+
+	var ran []string
+	var failing string
+	runner := hookRunnerMock(func(hook *Hook, env HookEnv) error {
+		ran = append(ran, hook.Name+":"+env.Component+":"+env.Action)
+		if hook.Name == "migrate" {
+			require.Equal(t, int32(4), env.Revision)
+		}
+		if hook.Name == failing {
+			return errors.New("hook `" + hook.Name + "` failed: exit status 1")
+		}
+		return nil
+	})
+
+	result, err := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages, WithHooks(runner, landscapeHooks)).Apply(des, cur)
+	require.NoError(t, err)
+	require.Equal(t, []string{"migrate:updated-one:update", "notify:updated-one:update", "smoke:updated-one:update", "notify:new-one:create"}, ran)
+	require.Equal(t, 1, updated)
+	require.Len(t, result.Succeeded("update"), 1)
+
+	// a failing pre hook aborts the component
+	ran, failing, updated = nil, "migrate", 0
+	result, err = NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, waitTimeout, disabledStages, WithHooks(runner, landscapeHooks)).Apply(des, cur)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "pre-update hook `migrate` failed")
+	require.Equal(t, 0, updated)
+
+	// hooks don't run in dry-run
+	ran, failing = nil, ""
+	_, err = NewExecutor(helmMock, chartLoadMock, secretsMock, true, false, waitTimeout, disabledStages, WithHooks(runner, landscapeHooks)).Apply(des, cur)
+	require.NoError(t, err)
+	require.Empty(t, ran)
+}
+
+func TestExecutorApplyRunsDeleteHooks(t *testing.T) {
+	des := newTestComponent("gone")
+	des.SecretValues = SecretValues{}
+	des.Hooks = &Hooks{
+		Pre:  []*Hook{{Name: "drain", Command: []string{"drain"}, Actions: []string{"delete"}}, {Name: "migrate", Command: []string{"migrate"}, Actions: []string{"update"}}},
+		Post: []*Hook{{Name: "smoke", Command: []string{"smoke"}}},
+	}
+	m, err := des.Configuration.GetMetadata()
+	require.NoError(t, err)
+	m.DeleteHooks = des.Hooks.forDelete()
+	des.Configuration.SetMetadata(m)
+
+	// the release keeps the delete hooks, so they can run once the component file is gone
+	raw, err := des.Configuration.YAML()
+	require.NoError(t, err)
+	cur, err := newComponentFromHelmRelease(&release.Release{
+		Name:      des.Name,
+		Namespace: des.Namespace,
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "connector-hdfs", Version: "0.1.0"}},
+		Config:    &chart.Config{Raw: raw},
+		Version:   3,
+	})
+	require.NoError(t, err)
+	require.Equal(t, &Hooks{Pre: des.Hooks.Pre[:1], Post: des.Hooks.Post}, cur.Hooks)
+	curMeta, err := cur.Configuration.GetMetadata()
+	require.NoError(t, err)
+	require.Equal(t, m, curMeta)
+	require.Equal(t, des.Configuration[metadataKey], cur.Configuration[metadataKey]) // no update just for the hooks
+
+	helmMock := &HelmclientMock{
+		deleteRelease: func(rlsName string, opts ...helm.DeleteOption) (*services.UninstallReleaseResponse, error) {
+			return nil, nil
+		},
+		installRelease: func(chStr, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			return nil, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+
+	var ran []string
+	runner := hookRunnerMock(func(hook *Hook, env HookEnv) error {
+		ran = append(ran, hook.Name+":"+env.Component+":"+env.Action)
+		return nil
+	})
+	executor := NewExecutor(helmMock, chartLoadMock, SecretsProviderMock{}, false, false, waitTimeout, disabledStages, WithHooks(runner, nil))
+
+	_, err = executor.Apply(Components{}, Components{cur.Name: cur})
+	require.NoError(t, err)
+	require.Equal(t, []string{"drain:gone:delete", "smoke:gone:delete"}, ran)
+
+	// the delete half of a replace runs the hooks of the replacement
+	moved := *des
+	moved.Namespace = "elsewhere"
+	moved.Hooks = &Hooks{Pre: []*Hook{{Name: "drain-v2", Command: []string{"drain"}, Actions: []string{"delete"}}}}
+	ran = nil
+	_, err = executor.Apply(Components{moved.Name: &moved}, Components{cur.Name: cur})
+	require.NoError(t, err)
+	require.Equal(t, []string{"drain-v2:gone:delete"}, ran)
+}
@@ -0,0 +1,85 @@
+package landscaper
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// An ignoreDifferences rule is a value path as shown in diffs, such as image.tag or podAnnotations["example.com/x"],
+// that also covers the paths below it; or a regular expression between slashes, such as /^.*\.replicas$/, that is
+// matched against the full paths.
+
+// ValidateIgnoreDifferences makes sure the regular expressions among rules compile
+func ValidateIgnoreDifferences(rules []string) error {
+	for _, rule := range rules {
+		if rule == "" {
+			return fmt.Errorf("empty ignoreDifferences rule")
+		}
+		if isRegexRule(rule) {
+			if _, err := regexp.Compile(rule[1 : len(rule)-1]); err != nil {
+				return fmt.Errorf("ignoreDifferences rule `%s` is not a valid regular expression: %s", rule, err)
+			}
+		}
+	}
+	return nil
+}
+
+func isRegexRule(rule string) bool {
+	return len(rule) > 1 && strings.HasPrefix(rule, "/") && strings.HasSuffix(rule, "/")
+}
+
+// ignoresPath tells whether one of rules matches path
+func ignoresPath(rules []string, path string) bool {
+	for _, rule := range rules {
+		if isRegexRule(rule) {
+			if re, err := regexp.Compile(rule[1 : len(rule)-1]); err == nil && re.MatchString(path) {
+				return true
+			}
+			continue
+		}
+		if path == rule || strings.HasPrefix(path, rule+".") || strings.HasPrefix(path, rule+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutIgnored returns a copy of cfg without the values at the paths that rules match. Maps that become empty are
+// left out as well.
+func withoutIgnored(cfg Configuration, rules []string) Configuration {
+	if len(rules) == 0 || cfg == nil {
+		return cfg
+	}
+
+	var prune func(path string, v interface{}) (interface{}, bool)
+	prune = func(path string, v interface{}) (interface{}, bool) {
+		if path != "" && ignoresPath(rules, path) {
+			return nil, false
+		}
+		switch t := v.(type) {
+		case Configuration:
+			return prune(path, map[string]interface{}(t))
+		case map[string]interface{}:
+			pruned := map[string]interface{}{}
+			for k, sub := range t {
+				if pv, keep := prune(joinPath(path, k), sub); keep {
+					pruned[k] = pv
+				}
+			}
+			return pruned, len(t) == 0 || len(pruned) > 0
+		case []interface{}:
+			pruned := []interface{}{}
+			for i, sub := range t {
+				if pv, keep := prune(fmt.Sprintf("%s[%d]", path, i), sub); keep {
+					pruned = append(pruned, pv)
+				}
+			}
+			return pruned, true
+		}
+		return v, true
+	}
+
+	pruned, _ := prune("", cfg)
+	return Configuration(pruned.(map[string]interface{}))
+}
@@ -0,0 +1,79 @@
+package landscaper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoresPath(t *testing.T) {
+	rules := []string{"image.tag", `podAnnotations["example.com/rev"]`, "/^autoscaled\\..*replicas$/"}
+
+	require.True(t, ignoresPath(rules, "image.tag"))
+	require.True(t, ignoresPath(rules, `podAnnotations["example.com/rev"]`))
+	require.True(t, ignoresPath(rules, "autoscaled.web.replicas"))
+	require.False(t, ignoresPath(rules, "image.tagSuffix"))
+	require.False(t, ignoresPath(rules, "image.repository"))
+	require.False(t, ignoresPath(rules, "replicas"))
+	require.True(t, ignoresPath([]string{"sidecars"}, "sidecars[0].image"))
+
+	require.NoError(t, ValidateIgnoreDifferences(rules))
+	require.Error(t, ValidateIgnoreDifferences([]string{"/(/"}))
+	require.Error(t, ValidateIgnoreDifferences([]string{""}))
+}
+
+func TestExecutorDiffIgnoresDifferences(t *testing.T) {
+	cur := newTestComponent("web")
+	cur.Configuration["image"] = map[string]interface{}{"repository": "nginx", "tag": "1.3-promoted"}
+	cur.Configuration["replicas"] = float64(7)
+
+	des := newTestComponent("web")
+	des.Configuration["image"] = map[string]interface{}{"repository": "nginx", "tag": "1.2"}
+	des.Configuration["replicas"] = float64(2)
+	des.IgnoreDifferences = []string{"image.tag"}
+
+	// the component's own rule isn't enough
+	changes, err := NewExecutor(&HelmclientMock{}, nil, nil, false, false, waitTimeout, disabledStages).Diff(Components{des.Name: des}, Components{cur.Name: cur})
+	require.NoError(t, err)
+	require.Len(t, changes.Update, 1)
+	require.Equal(t, []string{"  ~ replicas: 7 → 2"}, diffComponents(cur, changes.Update[des.Name]).Lines())
+
+	// with the landscape's rule, nothing differs
+	executor := NewExecutor(&HelmclientMock{}, nil, nil, false, false, waitTimeout, disabledStages, WithIgnoreDifferences([]string{"/replicas$/"}))
+	changes, err = executor.Diff(Components{des.Name: des}, Components{cur.Name: cur})
+	require.NoError(t, err)
+	require.True(t, changes.Empty())
+	require.Equal(t, []string{"image.tag"}, des.IgnoreDifferences)
+
+	// a real update still sends the ignored values
+	des.Configuration["FlushSize"] = float64(4)
+	changes, err = executor.Diff(Components{des.Name: des}, Components{cur.Name: cur})
+	require.NoError(t, err)
+	require.Equal(t, "1.2", changes.Update[des.Name].Configuration["image"].(map[string]interface{})["tag"])
+	require.Equal(t, []string{"  ~ FlushSize: 3 → 4"}, diffComponents(cur, changes.Update[des.Name]).Lines())
+}
+
+func TestExecutorDiffSecretRotationWithIgnoredDrift(t *testing.T) {
+	cur := newTestComponent("web")
+	cur.Configuration["image"] = map[string]interface{}{"repository": "nginx", "tag": "1.3-promoted"}
+
+	des := newTestComponent("web")
+	des.Configuration["image"] = map[string]interface{}{"repository": "nginx", "tag": "1.2"}
+	des.SecretValues["TestSecret1"] = []byte("rotated")
+	des.IgnoreDifferences = []string{"image.tag"}
+
+	require.True(t, isOnlySecretValueDiff(*cur, *des))
+
+	executor := NewExecutor(&HelmclientMock{}, nil, nil, false, false, waitTimeout, disabledStages, WithSecretsUpdateStrategy(SecretsUpdateRecreate))
+	changes, err := executor.Diff(Components{des.Name: des}, Components{cur.Name: cur})
+	require.NoError(t, err)
+	require.True(t, changes.Forced[des.Name])
+	require.Equal(t, "secret values changed: TestSecret1", changes.ForcedReasons[des.Name])
+
+	// with the landscape's rule as well
+	des.IgnoreDifferences = nil
+	executor = NewExecutor(&HelmclientMock{}, nil, nil, false, false, waitTimeout, disabledStages, WithSecretsUpdateStrategy(SecretsUpdateRecreate), WithIgnoreDifferences([]string{"image.tag"}))
+	changes, err = executor.Diff(Components{des.Name: des}, Components{cur.Name: cur})
+	require.NoError(t, err)
+	require.True(t, changes.Forced[des.Name])
+}
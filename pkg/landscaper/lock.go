@@ -0,0 +1,239 @@
+package landscaper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/typed/core/internalversion"
+)
+
+// the keys of the lock ConfigMap's data
+const (
+	lockHolder    = "holder"
+	lockExpiry    = "expiry"
+	lockPrefix    = "releaseNamePrefix"
+	lockNamespace = "namespace"
+)
+
+var (
+	// LockLeaseDuration is how long a lock is held without being renewed; the holder renews it well before it expires
+	LockLeaseDuration = 2 * time.Minute
+
+	// LockPollInterval is how often a locked landscape is checked while waiting for its lock
+	LockPollInterval = 5 * time.Second
+)
+
+// Lock is a lease on a landscape, identified by its release name prefix and namespace, that makes sure only one
+// landscaper at a time applies it. It is stored as a ConfigMap in the Tiller namespace.
+type Lock struct {
+	configMaps internalversion.ConfigMapInterface
+	name       string
+	prefix     string
+	namespace  string
+	holder     string
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{}
+	err  error // why the lock was lost; set before lost is closed
+}
+
+// NewLock creates a Lock on the landscape with the given release name prefix and namespace, kept in tillerNamespace
+func NewLock(kubeClient internalversion.CoreInterface, tillerNamespace, releaseNamePrefix, namespace string) *Lock {
+	h := sha256.Sum256([]byte(namespace + "/" + releaseNamePrefix))
+	hostname, _ := os.Hostname()
+	return &Lock{
+		configMaps: kubeClient.ConfigMaps(tillerNamespace),
+		name:       "landscaper-lock-" + hex.EncodeToString(h[:])[:16],
+		prefix:     releaseNamePrefix,
+		namespace:  namespace,
+		holder:     fmt.Sprintf("%s/%d", hostname, os.Getpid()),
+	}
+}
+
+// Acquire takes the lock, waiting up to timeout for another holder to release it or for its lease to expire.
+// While held, the lease is renewed in the background until Release is called.
+func (l *Lock) Acquire(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		holder, expiry, err := l.tryAcquire()
+		if err != nil {
+			return err
+		}
+		if holder == "" {
+			logrus.WithFields(logrus.Fields{"lock": l.name, "holder": l.holder}).Info("Acquired landscape lock")
+			l.renewInBackground()
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("landscape with prefix `%s` in namespace `%s` is locked by `%s` until %s; wait for it, use --lock-timeout, or break a stale lock with `landscaper unlock`", l.prefix, l.namespace, holder, expiry.Format(time.RFC3339))
+		}
+
+		logrus.WithFields(logrus.Fields{"lock": l.name, "holder": holder, "expiry": expiry}).Info("Landscape is locked; waiting")
+		time.Sleep(LockPollInterval)
+	}
+}
+
+// tryAcquire takes the lock if it is free, expired or already ours. Otherwise it returns the current holder and expiry.
+func (l *Lock) tryAcquire() (string, time.Time, error) {
+	cm, err := l.configMaps.Get(l.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = l.configMaps.Create(l.configMap(&core.ConfigMap{}))
+		if apierrors.IsAlreadyExists(err) {
+			return l.tryAcquire() // someone else was just ahead of us
+		}
+		return "", time.Time{}, err
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	holder, expiry := lockHolderAndExpiry(cm)
+	if holder != l.holder && time.Now().Before(expiry) {
+		return holder, expiry, nil
+	}
+
+	// the lock is expired or ours; the update fails with a conflict when someone else updates it in the meantime
+	_, err = l.configMaps.Update(l.configMap(cm))
+	if apierrors.IsConflict(err) {
+		return l.tryAcquire()
+	}
+	return "", time.Time{}, err
+}
+
+// configMap returns cm with its data set to a fresh lease for this holder
+func (l *Lock) configMap(cm *core.ConfigMap) *core.ConfigMap {
+	cm.Name = l.name
+	cm.Labels = map[string]string{"app": "landscaper", "purpose": "lock"}
+	cm.Data = map[string]string{
+		lockHolder:    l.holder,
+		lockExpiry:    time.Now().Add(LockLeaseDuration).UTC().Format(time.RFC3339),
+		lockPrefix:    l.prefix,
+		lockNamespace: l.namespace,
+	}
+	return cm
+}
+
+// renewInBackground renews the lease of the lock until Release is called. When the lock is taken over, or the lease
+// can't be renewed before it expires, the lock is lost: renewing stops and Lost and Err report it.
+func (l *Lock) renewInBackground() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stop, l.done, l.lost, l.err = make(chan struct{}), make(chan struct{}), make(chan struct{}), nil
+	go func(stop, done, lost chan struct{}) {
+		defer close(done)
+		lease := LockLeaseDuration
+		interval := lease / 3
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		renewed := time.Now()
+
+		lose := func(err error) {
+			logrus.WithFields(logrus.Fields{"lock": l.name, "error": err}).Error("Lost landscape lock")
+			l.err = err
+			close(lost)
+		}
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				holder, _, err := l.tryAcquire()
+				switch {
+				case holder != "":
+					lose(fmt.Errorf("landscape lock was taken over by `%s`", holder))
+					return
+				case err == nil:
+					renewed = time.Now()
+				case time.Since(renewed)+interval >= lease:
+					lose(fmt.Errorf("landscape lock expires before it can be renewed: %s", err))
+					return
+				default:
+					logrus.WithFields(logrus.Fields{"lock": l.name, "error": err}).Warn("Renewing landscape lock failed; retrying")
+				}
+			}
+		}
+	}(l.stop, l.done, l.lost)
+}
+
+// Lost returns a channel that is closed when the lock is lost while held
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Err returns why the lock was lost, or nil while it is held or wasn't acquired
+func (l *Lock) Err() error {
+	lost := l.Lost()
+	if lost == nil {
+		return nil
+	}
+	select {
+	case <-lost:
+		return l.err
+	default:
+		return nil
+	}
+}
+
+// Release stops renewing the lock and removes it, provided it is still ours
+func (l *Lock) Release() error {
+	l.mu.Lock()
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+	}
+	l.mu.Unlock()
+
+	cm, err := l.configMaps.Get(l.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if holder, _ := lockHolderAndExpiry(cm); holder != l.holder {
+		logrus.WithFields(logrus.Fields{"lock": l.name, "holder": holder}).Warn("Landscape lock was taken over; leaving it")
+		return nil
+	}
+
+	logrus.WithFields(logrus.Fields{"lock": l.name, "holder": l.holder}).Info("Released landscape lock")
+	return l.configMaps.Delete(l.name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &cm.UID}})
+}
+
+// Break removes the lock regardless of its holder, e.g. when a crashed landscaper left it behind.
+// It returns the holder of the removed lock, or an empty string when the landscape wasn't locked.
+func (l *Lock) Break() (string, error) {
+	cm, err := l.configMaps.Get(l.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	holder, _ := lockHolderAndExpiry(cm)
+	return holder, l.configMaps.Delete(l.name, &metav1.DeleteOptions{})
+}
+
+func lockHolderAndExpiry(cm *core.ConfigMap) (string, time.Time) {
+	expiry, err := time.Parse(time.RFC3339, cm.Data[lockExpiry])
+	if err != nil {
+		return cm.Data[lockHolder], time.Time{} // an unreadable expiry is treated as expired
+	}
+	return cm.Data[lockHolder], expiry
+}
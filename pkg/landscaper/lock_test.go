@@ -0,0 +1,88 @@
+package landscaper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/apis/core"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+)
+
+func TestLock(t *testing.T) {
+	defer func(poll time.Duration) { LockPollInterval = poll }(LockPollInterval)
+	LockPollInterval = 10 * time.Millisecond
+
+	kubeClient := fake.NewSimpleClientset().Core()
+
+	ours := NewLock(kubeClient, "kube-system", "pfx-", "ns")
+	theirs := NewLock(kubeClient, "kube-system", "pfx-", "ns")
+	theirs.holder = "ci-runner/42"
+	other := NewLock(kubeClient, "kube-system", "other-", "ns")
+	require.NotEqual(t, ours.name, other.name)
+
+	require.NoError(t, ours.Acquire(0))
+	require.NoError(t, other.Acquire(0)) // another landscape
+	require.NoError(t, other.Release())
+
+	err := theirs.Acquire(30 * time.Millisecond)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is locked by `"+ours.holder+"`")
+
+	require.NoError(t, ours.Release())
+	require.NoError(t, theirs.Acquire(0))
+
+	// an expired lease can be taken over; the previous holder leaves it alone on release
+	cm, err := kubeClient.ConfigMaps("kube-system").Get(ours.name, metav1.GetOptions{})
+	require.NoError(t, err)
+	cm.Data[lockExpiry] = time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+	_, err = kubeClient.ConfigMaps("kube-system").Update(cm)
+	require.NoError(t, err)
+	require.NoError(t, ours.Acquire(0))
+	require.NoError(t, theirs.Release())
+
+	holder, err := theirs.Break()
+	require.NoError(t, err)
+	require.Equal(t, ours.holder, holder)
+	holder, err = theirs.Break()
+	require.NoError(t, err)
+	require.Equal(t, "", holder)
+	require.NoError(t, ours.Release())
+}
+
+func TestLockLost(t *testing.T) {
+	defer func(lease time.Duration) { LockLeaseDuration = lease }(LockLeaseDuration)
+	LockLeaseDuration = 30 * time.Millisecond
+
+	kubeClient := fake.NewSimpleClientset().Core()
+	ours := NewLock(kubeClient, "kube-system", "pfx-", "ns")
+	require.NoError(t, ours.Err())
+
+	require.NoError(t, ours.Acquire(0))
+	time.Sleep(2 * LockLeaseDuration) // renewed meanwhile
+	require.NoError(t, ours.Err())
+
+	// someone breaks the lock and takes it
+	theirs := NewLock(kubeClient, "kube-system", "pfx-", "ns")
+	theirs.holder = "ci-runner/42"
+	_, err := theirs.Break()
+	require.NoError(t, err)
+	cm := theirs.configMap(&core.ConfigMap{})
+	cm.Data[lockExpiry] = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	_, err = kubeClient.ConfigMaps("kube-system").Create(cm)
+	require.NoError(t, err)
+
+	select {
+	case <-ours.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("the loss of the lock wasn't reported")
+	}
+	require.Error(t, ours.Err())
+	require.Contains(t, ours.Err().Error(), "taken over by `ci-runner/42`")
+	require.NoError(t, ours.Release())
+
+	holder, err := theirs.Break()
+	require.NoError(t, err)
+	require.Equal(t, "ci-runner/42", holder) // left alone by ours
+}
@@ -0,0 +1,356 @@
+package landscaper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/releaseutil"
+)
+
+// ObjectDiff is the difference between the current and the desired manifest of a single Kubernetes object
+type ObjectDiff struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Change    string `json:"change"`         // added, removed or changed; like the kinds of ValueChange
+	Diff      string `json:"diff,omitempty"` // unified diff of the manifests as YAML
+}
+
+// ManifestDiff holds the differences per Kubernetes object between the current and the desired manifest of a component
+type ManifestDiff struct {
+	Component string        `json:"component"`
+	Action    string        `json:"action"` // create, update, adopt, replace or delete
+	Objects   []*ObjectDiff `json:"objects,omitempty"`
+}
+
+// ManifestDiffer renders the manifests of components, to show what changes in the cluster rather than in the values
+type ManifestDiffer struct {
+	helmClient  helm.Interface
+	chartLoader ChartLoader
+}
+
+// manifestObject is a Kubernetes object from a manifest, with just enough structure to identify it
+type manifestObject struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+// NewManifestDiffer creates a ManifestDiffer. It gets current manifests from Tiller, and renders desired ones with dry-run
+// installs and upgrades.
+func NewManifestDiffer(helmClient helm.Interface, chartLoader ChartLoader) *ManifestDiffer {
+	return &ManifestDiffer{helmClient, chartLoader}
+}
+
+// Diffs returns the differences in manifests per changed component, in the order in which they would be applied.
+// Blocked changes are left out, since they don't change the cluster.
+func (md *ManifestDiffer) Diffs(changes *Changes, current Components) ([]*ManifestDiff, error) {
+	diffs := []*ManifestDiff{}
+	for _, cd := range changes.Diffs(current) {
+		if cd.Blocked {
+			continue
+		}
+
+		var err error
+		var cur, des string
+		if cd.Action != "create" {
+			if cur, err = md.currentManifest(cd.Component); err != nil {
+				return nil, err
+			}
+		}
+		switch cd.Action {
+		case "update", "adopt":
+			des, err = md.renderUpdate(changes.Update[cd.Component])
+		case "create", "replace":
+			des, err = md.renderInstall(changes.Create[cd.Component])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// a replace may move the release to another namespace
+		curNamespace, desNamespace := "", ""
+		if cmp := current[cd.Component]; cmp != nil {
+			curNamespace = cmp.Namespace
+		}
+		if cmp := changes.Create[cd.Component]; cmp != nil {
+			desNamespace = cmp.Namespace
+		}
+		if cmp := changes.Update[cd.Component]; cmp != nil {
+			desNamespace = cmp.Namespace
+		}
+
+		objects, err := diffManifests(cur, des, curNamespace, desNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff manifests of `%s`: %s", cd.Component, err)
+		}
+		diffs = append(diffs, &ManifestDiff{Component: cd.Component, Action: cd.Action, Objects: objects})
+	}
+	return diffs, nil
+}
+
+// currentManifest returns the manifest of the deployed release
+func (md *ManifestDiffer) currentManifest(name string) (string, error) {
+	res, err := md.helmClient.ReleaseContent(name)
+	if err != nil {
+		return "", errors.New(grpc.ErrorDesc(err))
+	}
+	return res.GetRelease().GetManifest(), nil
+}
+
+// renderUpdate returns the manifest that upgrading the release of cmp would result in
+func (md *ManifestDiffer) renderUpdate(cmp *Component) (string, error) {
+	chartPath, rawValues, err := md.chart(cmp)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := md.helmClient.UpdateRelease(
+		cmp.Name,
+		chartPath,
+		helm.UpdateValueOverrides([]byte(rawValues)),
+		helm.UpgradeDryRun(true),
+	)
+	if err != nil {
+		return "", errors.New(grpc.ErrorDesc(err))
+	}
+	return res.GetRelease().GetManifest(), nil
+}
+
+// renderInstall returns the manifest that installing cmp would result in. Since a replaced release still exists, Tiller
+// picks the name of the simulated release; it is put back in the manifest so that objects named after it line up.
+func (md *ManifestDiffer) renderInstall(cmp *Component) (string, error) {
+	chartPath, rawValues, err := md.chart(cmp)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := md.helmClient.InstallRelease(
+		chartPath,
+		cmp.Namespace,
+		helm.ValueOverrides([]byte(rawValues)),
+		helm.InstallDryRun(true),
+	)
+	if err != nil {
+		return "", errors.New(grpc.ErrorDesc(err))
+	}
+
+	manifest := res.GetRelease().GetManifest()
+	if generated := res.GetRelease().GetName(); generated != "" {
+		manifest = strings.Replace(manifest, generated, cmp.Name, -1)
+	}
+	return manifest, nil
+}
+
+// chart returns the local path of the chart of cmp, and its values as YAML
+func (md *ManifestDiffer) chart(cmp *Component) (string, string, error) {
+	chartRef, err := cmp.FullChartRef()
+	if err != nil {
+		return "", "", err
+	}
+	_, chartPath, err := md.chartLoader.Load(chartRef)
+	if err != nil {
+		return "", "", err
+	}
+	rawValues, err := cmp.Configuration.YAML()
+	if err != nil {
+		return "", "", err
+	}
+
+	logrus.WithFields(logrus.Fields{"release": cmp.Name, "chartPath": chartPath}).Debug("Render manifest")
+	return chartPath, rawValues, nil
+}
+
+// diffManifests splits both manifests into objects and diffs them per object, sorted by kind, namespace and name.
+// Objects without a namespace are attributed to the namespace of the release they belong to: curNamespace for those of
+// the current manifest and desNamespace for those of the desired one.
+func diffManifests(current, desired, curNamespace, desNamespace string) ([]*ObjectDiff, error) {
+	cObjects, err := splitManifest(current, curNamespace)
+	if err != nil {
+		return nil, err
+	}
+	dObjects, err := splitManifest(desired, desNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for key := range cObjects {
+		keys = append(keys, key)
+	}
+	for key := range dObjects {
+		if _, ok := cObjects[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	diffs := []*ObjectDiff{}
+	for _, key := range keys {
+		c, inCurrent := cObjects[key]
+		d, inDesired := dObjects[key]
+		if inCurrent && inDesired && reflect.DeepEqual(c.content, d.content) {
+			continue
+		}
+
+		obj := d
+		od := &ObjectDiff{Change: ValueChanged}
+		switch {
+		case !inCurrent:
+			od.Change = ValueAdded
+		case !inDesired:
+			od.Change, obj = ValueRemoved, c
+		}
+		od.Kind, od.Namespace, od.Name = obj.Kind, obj.Metadata.Namespace, obj.Metadata.Name
+
+		if od.Change != ValueRemoved {
+			if inCurrent {
+				c.content = redaction.manifest(c.content, nil).(map[string]interface{})
+			}
+			d.content = redaction.manifest(d.content, cObjects[key].content).(map[string]interface{})
+			if obj.Kind == "Secret" {
+				c.content, d.content = hideSecretData(c.content, d.content)
+			}
+			cYAML, dYAML := "", ""
+			if inCurrent {
+				if cYAML, err = objectYAML(c.content); err != nil {
+					return nil, err
+				}
+			}
+			if dYAML, err = objectYAML(d.content); err != nil {
+				return nil, err
+			}
+			if od.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(cYAML),
+				FromFile: "current",
+				B:        difflib.SplitLines(dYAML),
+				ToFile:   "desired",
+				Context:  3,
+			}); err != nil {
+				return nil, err
+			}
+		}
+		diffs = append(diffs, od)
+	}
+	return diffs, nil
+}
+
+// renderedObject is an object of a manifest
+type renderedObject struct {
+	manifestObject
+	content map[string]interface{}
+}
+
+// splitManifest returns the objects of manifest by kind, namespace and name
+func splitManifest(manifest, namespace string) (map[string]renderedObject, error) {
+	objects := map[string]renderedObject{}
+	for _, doc := range releaseutil.SplitManifests(manifest) {
+		obj := renderedObject{content: map[string]interface{}{}}
+		if err := yaml.Unmarshal([]byte(doc), &obj.manifestObject); err != nil {
+			return nil, err
+		}
+		if obj.Kind == "" {
+			continue // a template that rendered nothing but comments
+		}
+		if obj.Metadata.Namespace == "" {
+			obj.Metadata.Namespace = namespace
+		}
+		if err := yaml.Unmarshal([]byte(doc), &obj.content); err != nil {
+			return nil, err
+		}
+
+		objects[fmt.Sprintf("%s/%s/%s", obj.Kind, obj.Metadata.Namespace, obj.Metadata.Name)] = obj
+	}
+	return objects, nil
+}
+
+// objectYAML formats the content of an object as YAML, with sorted keys so that their order doesn't matter
+func objectYAML(content map[string]interface{}) (string, error) {
+	out, err := yaml.Marshal(content)
+	return string(out), err
+}
+
+// hideSecretData returns copies of the current and desired content of a Secret with the values of its data hidden, so
+// that the diff shows which keys are added, removed or changed, but not what they contain. current may be nil.
+func hideSecretData(current, desired map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	hide := func(content, other map[string]interface{}) map[string]interface{} {
+		if content == nil {
+			return nil
+		}
+		hidden := map[string]interface{}{}
+		for k, v := range content {
+			hidden[k] = v
+		}
+		for _, field := range []string{"data", "stringData"} {
+			data, ok := content[field].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			otherData, _ := other[field].(map[string]interface{})
+			hiddenData := map[string]interface{}{}
+			for k, v := range data {
+				hiddenData[k] = "<hidden>"
+				if ov, ok := otherData[k]; ok && ov != v {
+					hiddenData[k] = "<hidden, changed>"
+				}
+			}
+			hidden[field] = hiddenData
+		}
+		return hidden
+	}
+	return hide(current, nil), hide(desired, current)
+}
+
+// String names the object, e.g. `Deployment my-namespace/my-app`
+func (od *ObjectDiff) String() string {
+	if od.Namespace == "" {
+		return fmt.Sprintf("%s %s", od.Kind, od.Name)
+	}
+	return fmt.Sprintf("%s %s/%s", od.Kind, od.Namespace, od.Name)
+}
+
+// WriteManifestDiffs writes the differences per object of each component to w as text
+func WriteManifestDiffs(w io.Writer, diffs []*ManifestDiff) error {
+	markers := map[string]string{ValueAdded: "+", ValueRemoved: "-", ValueChanged: "~"}
+	for _, md := range diffs {
+		title := (&ComponentDiff{Component: md.Component, Action: md.Action}).Title()
+		if _, err := fmt.Fprintln(w, title); err != nil {
+			return err
+		}
+		for _, od := range md.Objects {
+			if _, err := fmt.Fprintf(w, "  %s %s\n", markers[od.Change], od); err != nil {
+				return err
+			}
+			for _, line := range difflib.SplitLines(od.Diff) {
+				if strings.TrimSpace(line) == "" {
+					continue
+				}
+				if _, err := fmt.Fprint(w, "    "+line); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WriteManifestDiffsYAML writes the differences per object of each component to w as YAML
+func WriteManifestDiffsYAML(w io.Writer, diffs []*ManifestDiff) error {
+	out, err := yaml.Marshal(diffs)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
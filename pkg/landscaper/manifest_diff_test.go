@@ -0,0 +1,172 @@
+package landscaper
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+const currentManifest = `---
+# Source: web/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: nginx:1.2
+---
+# Source: web/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: web
+data:
+  password: c2VjcmV0
+  user: YWRtaW4=
+---
+# Source: web/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web-legacy
+`
+
+const desiredManifest = `---
+# Source: web/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: web
+data:
+  user: YWRtaW4=
+  password: cm90YXRlZA==
+---
+# Source: web/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - image: nginx:1.2
+        name: web
+      - name: proxy
+        image: envoy:1.10
+---
+# Source: web/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: edge
+---
+# Source: web/templates/optional.yaml
+`
+
+func TestManifestDiffer(t *testing.T) {
+	cur := newTestComponent("web")
+	des := newTestComponent("web")
+	des.Configuration["FlushSize"] = 4
+	nu := newTestComponent("new")
+
+	helmMock := &HelmclientMock{
+		releaseContent: func(rlsName string, opts ...helm.ContentOption) (*services.GetReleaseContentResponse, error) {
+			return &services.GetReleaseContentResponse{Release: &release.Release{Name: rlsName, Manifest: currentManifest}}, nil
+		},
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			return &services.UpdateReleaseResponse{Release: &release.Release{Name: rlsName, Manifest: desiredManifest}}, nil
+		},
+		installRelease: func(chStr, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			return &services.InstallReleaseResponse{Release: &release.Release{Name: "wobbly-panda", Manifest: "kind: ConfigMap\nmetadata:\n  name: wobbly-panda-config\n"}}, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+
+	changes := &Changes{
+		Create: Components{nu.Name: nu},
+		Update: Components{des.Name: des},
+		Delete: Components{},
+		Forced: map[string]bool{},
+		Adopt:  map[string]bool{},
+	}
+	diffs, err := NewManifestDiffer(helmMock, chartLoadMock).Diffs(changes, Components{cur.Name: cur})
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+
+	update := diffs[0]
+	require.Equal(t, "update", update.Action)
+	require.Len(t, update.Objects, 4)
+	require.Equal(t, "ConfigMap myNameSpace/web-legacy", update.Objects[0].String())
+	require.Equal(t, ValueRemoved, update.Objects[0].Change)
+	require.Equal(t, "Deployment myNameSpace/web", update.Objects[1].String())
+	require.Equal(t, ValueChanged, update.Objects[1].Change)
+	require.Contains(t, update.Objects[1].Diff, "+      - image: envoy:1.10\n+        name: proxy\n")
+	require.Equal(t, "Secret myNameSpace/web", update.Objects[2].String())
+	require.Contains(t, update.Objects[2].Diff, "-  password: <hidden>\n+  password: <hidden, changed>\n")
+	require.NotContains(t, update.Objects[2].Diff, "c2VjcmV0")
+	require.NotContains(t, update.Objects[2].Diff, "YWRtaW4=")
+	require.Equal(t, "Service edge/web", update.Objects[3].String())
+	require.Equal(t, ValueAdded, update.Objects[3].Change)
+
+	// the name Tiller generates for a simulated install is replaced by the name of the component
+	create := diffs[1]
+	require.Equal(t, "create", create.Action)
+	require.Equal(t, "ConfigMap myNameSpace/new-config", create.Objects[0].String())
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteManifestDiffs(buf, diffs))
+	require.Contains(t, buf.String(), "Update: web\n  - ConfigMap myNameSpace/web-legacy\n  ~ Deployment myNameSpace/web\n    --- current\n")
+	require.Contains(t, buf.String(), "Create: new\n  + ConfigMap myNameSpace/new-config\n")
+}
+
+func TestManifestDifferReplaceMovesNamespace(t *testing.T) {
+	cur := newTestComponent("web")
+	des := newTestComponent("web")
+	des.Namespace = "elsewhere"
+	manifest := "kind: ConfigMap\nmetadata:\n  name: web-config\n"
+
+	helmMock := &HelmclientMock{
+		releaseContent: func(rlsName string, opts ...helm.ContentOption) (*services.GetReleaseContentResponse, error) {
+			return &services.GetReleaseContentResponse{Release: &release.Release{Name: rlsName, Manifest: manifest}}, nil
+		},
+		installRelease: func(chStr, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			return &services.InstallReleaseResponse{Release: &release.Release{Name: "wobbly-panda", Manifest: manifest}}, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+
+	changes := &Changes{
+		Create: Components{des.Name: des},
+		Update: Components{},
+		Delete: Components{cur.Name: cur},
+		Forced: map[string]bool{des.Name: true},
+		Adopt:  map[string]bool{},
+	}
+	diffs, err := NewManifestDiffer(helmMock, chartLoadMock).Diffs(changes, Components{cur.Name: cur})
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+
+	// the objects of the current release stay in its namespace; those of the new one go to the desired namespace
+	replace := diffs[0]
+	require.Equal(t, "replace", replace.Action)
+	require.Len(t, replace.Objects, 2)
+	require.Equal(t, "ConfigMap elsewhere/web-config", replace.Objects[0].String())
+	require.Equal(t, ValueAdded, replace.Objects[0].Change)
+	require.Equal(t, "ConfigMap myNameSpace/web-config", replace.Objects[1].String())
+	require.Equal(t, ValueRemoved, replace.Objects[1].Change)
+}
@@ -4,10 +4,20 @@ var (
 	metadataKey        = "_landscaper_metadata"
 	metaReleaseVersion = "releaseversion"
 	metaChartRepo      = "chartrepository"
+	metaDependsOn      = "dependson"
+	metaProtect        = "protect"
+	metaLabels         = "labels"
+	metaCommit         = "commit"
+	metaDeleteHooks    = "deletehooks"
 )
 
 // Metadata holds landscaper metadata that is attached to a component/release through its Configuration
 type Metadata struct {
 	ReleaseVersion  string
 	ChartRepository string
+	DependsOn       []string
+	Protect         bool
+	Labels          map[string]string
+	Commit          string // the git commit the desired state was read from, if it came from git
+	DeleteHooks     *Hooks // the hooks that run when the component is deleted, which is when its file is gone
 }
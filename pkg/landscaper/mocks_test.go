@@ -7,13 +7,20 @@ import (
 )
 
 type HelmclientMock struct {
-	deleteRelease  func(rlsName string, opts ...helm.DeleteOption) (*services.UninstallReleaseResponse, error)
-	installRelease func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error)
-	updateRelease  func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error)
-	listReleases   func(opts ...helm.ReleaseListOption) (*services.ListReleasesResponse, error)
+	deleteRelease   func(rlsName string, opts ...helm.DeleteOption) (*services.UninstallReleaseResponse, error)
+	installRelease  func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error)
+	updateRelease   func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error)
+	listReleases    func(opts ...helm.ReleaseListOption) (*services.ListReleasesResponse, error)
+	rollbackRelease func(rlsName string, opts ...helm.RollbackOption) (*services.RollbackReleaseResponse, error)
+	releaseHistory  func(rlsName string, opts ...helm.HistoryOption) (*services.GetHistoryResponse, error)
+	releaseContent  func(rlsName string, opts ...helm.ContentOption) (*services.GetReleaseContentResponse, error)
+	runReleaseTest  func(rlsName string, opts ...helm.ReleaseTestOption) (<-chan *services.TestReleaseResponse, <-chan error)
 }
 
 func (m *HelmclientMock) ListReleases(opts ...helm.ReleaseListOption) (*services.ListReleasesResponse, error) {
+	if m.listReleases == nil {
+		return &services.ListReleasesResponse{}, nil
+	}
 	return m.listReleases(opts...)
 }
 
@@ -42,15 +49,18 @@ func (m *HelmclientMock) UpdateReleaseFromChart(rlsName string, chart *chart.Cha
 }
 
 func (m *HelmclientMock) RollbackRelease(rlsName string, opts ...helm.RollbackOption) (*services.RollbackReleaseResponse, error) {
-	return nil, nil
+	return m.rollbackRelease(rlsName, opts...)
 }
 
 func (m *HelmclientMock) ReleaseContent(rlsName string, opts ...helm.ContentOption) (*services.GetReleaseContentResponse, error) {
-	return nil, nil
+	if m.releaseContent == nil {
+		return nil, nil
+	}
+	return m.releaseContent(rlsName, opts...)
 }
 
 func (m *HelmclientMock) ReleaseHistory(rlsName string, opts ...helm.HistoryOption) (*services.GetHistoryResponse, error) {
-	return nil, nil
+	return m.releaseHistory(rlsName, opts...)
 }
 
 func (m *HelmclientMock) GetVersion(opts ...helm.VersionOption) (*services.GetVersionResponse, error) {
@@ -58,7 +68,10 @@ func (m *HelmclientMock) GetVersion(opts ...helm.VersionOption) (*services.GetVe
 }
 
 func (m *HelmclientMock) RunReleaseTest(rlsName string, opts ...helm.ReleaseTestOption) (<-chan *services.TestReleaseResponse, <-chan error) {
-	return nil, nil
+	if m.runReleaseTest == nil {
+		return nil, nil
+	}
+	return m.runReleaseTest(rlsName, opts...)
 }
 
 func (m *HelmclientMock) PingTiller() error {
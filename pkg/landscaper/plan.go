@@ -0,0 +1,292 @@
+package landscaper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// PlanVersion is the version of the plan format written by this landscaper
+const PlanVersion = 1
+
+var (
+	// ErrPlanOutdated is an error to indicate the current state has changed since the plan was made
+	ErrPlanOutdated = errors.New("current state differs from the state the plan was computed against")
+)
+
+// Plan is a saved set of Changes, together with a hash of the current state it was computed against.
+// Secret values are never stored in a plan; they are read again when the plan is applied, and must match the salted
+// hashes of the values the plan was made with.
+type Plan struct {
+	Version   int                 `json:"version"`
+	StateHash string              `json:"stateHash"`
+	Salt      string              `json:"salt"` // mixed into the hashes of secret values and adoptions; unique per plan
+	Create    []*PlannedComponent `json:"create"`
+	Update    []*PlannedComponent `json:"update"`
+	Delete    []*PlannedComponent `json:"delete"`
+	Forced    []string            `json:"forced"`
+	Adopt     []string            `json:"adopt,omitempty"`
+	AdoptHash string              `json:"adoptHash"`
+
+	ForcedReasons map[string]string `json:"forcedReasons,omitempty"`
+	Blocked       map[string]string `json:"blocked,omitempty"`
+	Selection     string            `json:"selection,omitempty"` // the ComponentFilter the plan was made with, if any
+	Selected      []string          `json:"selected,omitempty"`  // the current components the selection covered
+}
+
+// PlannedComponent is a Component as stored in a Plan
+type PlannedComponent struct {
+	Name          string        `json:"name"`
+	Namespace     string        `json:"namespace"`
+	ChartRef      string        `json:"chartRef"`
+	Release       *Release      `json:"release"`
+	Configuration Configuration `json:"configuration"`
+	SecretNames   SecretNames   `json:"secretNames,omitempty"`
+	SecretsHash   string        `json:"secretsHash,omitempty"` // the salted hash of the secret values
+	Hooks         *Hooks        `json:"hooks,omitempty"`
+	Test          bool          `json:"test,omitempty"`
+}
+
+// NewPlan creates a Plan that holds the given changes to the current state
+func NewPlan(changes *Changes, current Components) (*Plan, error) {
+	hash, err := stateHash(current)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{Version: PlanVersion, StateHash: hash, Salt: hex.EncodeToString(newSecretsSalt()), Forced: []string{}, ForcedReasons: changes.ForcedReasons, Blocked: changes.Blocked}
+	if p.Create, err = p.newPlannedComponents(changes.Create); err != nil {
+		return nil, err
+	}
+	if p.Update, err = p.newPlannedComponents(changes.Update); err != nil {
+		return nil, err
+	}
+	if p.Delete, err = p.newPlannedComponents(changes.Delete); err != nil {
+		return nil, err
+	}
+	for name, forced := range changes.Forced {
+		if forced {
+			p.Forced = append(p.Forced, name)
+		}
+	}
+	sort.Strings(p.Forced)
+
+	for name, adopt := range changes.Adopt {
+		if adopt {
+			p.Adopt = append(p.Adopt, name)
+		}
+	}
+	sort.Strings(p.Adopt)
+	p.AdoptHash = p.hash([]byte(strings.Join(p.Adopt, "\n")))
+
+	return p, nil
+}
+
+// SetSelection records the filter the plan was made with, and the names of the current components it selected
+func (p *Plan) SetSelection(filter *ComponentFilter, current Components) {
+	if filter.Empty() {
+		return
+	}
+	p.Selection = filter.String()
+	p.Selected = current.names()
+}
+
+// SelectCurrent returns the current components the plan applies to: those it selected when it was made, and those
+// filter selects now. A release that came to match since then makes the state hash differ.
+func (p *Plan) SelectCurrent(current Components, filter *ComponentFilter) Components {
+	if filter.Empty() {
+		return current
+	}
+
+	names := map[string]bool{}
+	for _, name := range p.Selected {
+		names[name] = true
+	}
+	for name, cmp := range current {
+		if filter.Matches(cmp) {
+			names[name] = true
+		}
+	}
+	return selectNames(current, names)
+}
+
+// ReadPlan decodes a Plan and makes sure its version is supported
+func ReadPlan(r io.Reader) (*Plan, error) {
+	p := &Plan{}
+	if err := json.NewDecoder(r).Decode(p); err != nil {
+		return nil, err
+	}
+
+	if p.Version != PlanVersion {
+		return nil, fmt.Errorf("unsupported plan version %d; expecting version %d", p.Version, PlanVersion)
+	}
+
+	return p, nil
+}
+
+// Write encodes the Plan as indented JSON
+func (p *Plan) Write(w io.Writer) error {
+	bs, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bs, '\n'))
+	return err
+}
+
+// Changes reconstructs the planned Changes. It refuses when current isn't the state the plan was computed against, or
+// the secret values or adoptions differ from those the plan was made with. Secret values of to-be-created and
+// to-be-updated components are read with secrets; to-be-deleted components are taken from current. Whether the
+// releases to adopt are still exactly those that need adoption is up to Executor.CheckAdoptions.
+func (p *Plan) Changes(current Components, secrets SecretsReader) (*Changes, error) {
+	hash, err := stateHash(current)
+	if err != nil {
+		return nil, err
+	}
+	if hash != p.StateHash {
+		return nil, ErrPlanOutdated
+	}
+	if p.hash([]byte(strings.Join(p.Adopt, "\n"))) != p.AdoptHash {
+		return nil, errors.New("the releases to adopt differ from those the plan was made with")
+	}
+
+	changes := &Changes{Create: Components{}, Update: Components{}, Delete: Components{}, Forced: map[string]bool{}, Adopt: map[string]bool{}, ForcedReasons: p.ForcedReasons, Blocked: p.Blocked}
+
+	for _, pc := range p.Delete {
+		cmp, ok := current[pc.Name]
+		if !ok {
+			return nil, fmt.Errorf("planned deletion of `%s` but it doesn't exist", pc.Name)
+		}
+		changes.Delete[pc.Name] = cmp
+	}
+
+	for _, pcs := range []struct {
+		planned []*PlannedComponent
+		target  Components
+	}{{p.Create, changes.Create}, {p.Update, changes.Update}} {
+		for _, pc := range pcs.planned {
+			cmp, err := pc.component(secrets)
+			if err != nil {
+				return nil, err
+			}
+			if p.secretsHash(cmp.SecretValues) != pc.SecretsHash {
+				return nil, fmt.Errorf("secret values of `%s` changed since the plan was made", cmp.Name)
+			}
+			pcs.target[cmp.Name] = cmp
+		}
+	}
+
+	for _, name := range p.Forced {
+		changes.Forced[name] = true
+	}
+	for _, name := range p.Adopt {
+		changes.Adopt[name] = true
+	}
+
+	return changes, nil
+}
+
+// newPlannedComponents converts components to their planned form, sorted by name
+func (p *Plan) newPlannedComponents(cs Components) ([]*PlannedComponent, error) {
+	pcs := []*PlannedComponent{}
+	for _, name := range cs.names() {
+		cmp := cs[name]
+		chartRef, err := cmp.FullChartRef()
+		if err != nil {
+			return nil, err
+		}
+		pcs = append(pcs, &PlannedComponent{
+			Name:          cmp.Name,
+			Namespace:     cmp.Namespace,
+			ChartRef:      chartRef,
+			Release:       cmp.Release,
+			Configuration: cmp.Configuration,
+			SecretNames:   cmp.SecretNames,
+			SecretsHash:   p.secretsHash(cmp.SecretValues),
+			Hooks:         cmp.Hooks,
+			Test:          cmp.Test,
+		})
+	}
+	return pcs, nil
+}
+
+// component turns a PlannedComponent back into a Component, reading its secret values
+func (pc *PlannedComponent) component(secrets SecretsReader) (*Component, error) {
+	cmp := NewComponent(pc.Name, pc.Namespace, pc.Release, pc.Configuration, Configurations{}, pc.SecretNames)
+	cmp.Hooks = pc.Hooks
+	cmp.Test = pc.Test
+
+	chartRef, err := cmp.FullChartRef()
+	if err != nil {
+		return nil, err
+	}
+	if chartRef != pc.ChartRef {
+		return nil, fmt.Errorf("planned component `%s` has chart ref `%s` but its release and metadata point to `%s`", pc.Name, pc.ChartRef, chartRef)
+	}
+
+	if len(cmp.SecretNames) > 0 {
+		secr, err := secrets.Read(cmp.Name, cmp.Namespace, cmp.SecretNames)
+		if err != nil {
+			return nil, err
+		}
+		cmp.SecretValues = secr
+		cmp.setSecretsChecksum()
+		cmp.setSecretSources(secrets)
+		redaction.addSecretValues(cmp.SecretValues)
+	}
+
+	return cmp, nil
+}
+
+// secretsHash returns the salted hash of values, or an empty string when there are none
+func (p *Plan) secretsHash(values SecretValues) string {
+	if len(values) == 0 {
+		return ""
+	}
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bs := []byte{}
+	for _, key := range keys {
+		bs = append(append(append(bs, key...), 0), values[key]...)
+		bs = append(bs, 0)
+	}
+	return p.hash(bs)
+}
+
+// hash returns the hash of bs salted with the plan's salt
+func (p *Plan) hash(bs []byte) string {
+	h := sha256.New()
+	h.Write([]byte(p.Salt))
+	h.Write(bs)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// stateHash returns a hash that identifies the given state, including its secret values
+func stateHash(cs Components) (string, error) {
+	h := sha256.New()
+	for _, name := range cs.names() {
+		cmp := cs[name]
+		bs, err := json.Marshal(cmp)
+		if err != nil {
+			return "", err
+		}
+		h.Write(bs)
+
+		// SecretValues aren't part of the component's JSON; json sorts the keys so this is stable
+		bs, err = json.Marshal(cmp.SecretValues)
+		if err != nil {
+			return "", err
+		}
+		h.Write(bs)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
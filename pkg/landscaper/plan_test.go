@@ -0,0 +1,133 @@
+package landscaper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+func TestPlanRoundTrip(t *testing.T) {
+	nu := newTestComponent("new-one")
+	rem := newTestComponent("busted-one")
+	up := newTestComponent("updated-one")
+	updiff := newTestComponent("updated-one")
+	updiff.Configuration["FlushSize"] = 4
+	moved := newTestComponent("moved-one")
+	movediff := newTestComponent("moved-one")
+	movediff.Namespace = "elsewhere"
+
+	des := Components{nu.Name: nu, updiff.Name: updiff, movediff.Name: movediff}
+	cur := Components{rem.Name: rem, up.Name: up, moved.Name: moved}
+
+	changes, err := NewExecutor(&HelmclientMock{}, nil, nil, false, false, waitTimeout, disabledStages).Diff(des, cur)
+	require.NoError(t, err)
+
+	plan, err := NewPlan(changes, cur)
+	require.NoError(t, err)
+	require.Equal(t, PlanVersion, plan.Version)
+	require.Equal(t, []string{"moved-one"}, plan.Forced)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, plan.Write(buf))
+	require.NotContains(t, buf.String(), "secret value", "secret values must never end up in a plan")
+
+	plan, err = ReadPlan(buf)
+	require.NoError(t, err)
+
+	secretsMock := SecretsProviderMock{
+		read: func(componentName, namespace string, secretNames SecretNames) (SecretValues, error) {
+			return SecretValues{"TestSecret1": []byte("secret value 1"), "TestSecret2": []byte("secret value 2")}, nil
+		},
+	}
+
+	planned, err := plan.Changes(cur, secretsMock)
+	require.NoError(t, err)
+	require.Equal(t, changes.Forced, planned.Forced)
+	require.Equal(t, changes.Delete, planned.Delete)
+	require.Equal(t, changes.Create.names(), planned.Create.names())
+	require.Equal(t, changes.Update.names(), planned.Update.names())
+	require.Equal(t, float64(4), planned.Update["updated-one"].Configuration["FlushSize"])
+	require.Equal(t, nu.SecretValues, planned.Create["new-one"].SecretValues)
+	require.Equal(t, "elsewhere", planned.Create["moved-one"].Namespace)
+
+	// the cluster changed after the plan was made
+	cur[up.Name] = updiff
+	_, err = plan.Changes(cur, secretsMock)
+	require.Equal(t, ErrPlanOutdated, err)
+}
+
+func TestPlanRefusesChangedSecretsAndAdoptions(t *testing.T) {
+	nu := newTestComponent("new-one")
+	des := Components{nu.Name: nu}
+	cur := Components{}
+
+	var releases []*release.Release
+	helmMock := &HelmclientMock{
+		listReleases: func(opts ...helm.ReleaseListOption) (*services.ListReleasesResponse, error) {
+			return &services.ListReleasesResponse{Releases: releases}, nil
+		},
+	}
+	executor := NewExecutor(helmMock, nil, nil, false, false, waitTimeout, disabledStages, WithAdoption(true))
+	changes, err := executor.Diff(des, cur)
+	require.NoError(t, err)
+
+	plan, err := NewPlan(changes, cur)
+	require.NoError(t, err)
+	require.NotEmpty(t, plan.Create[0].SecretsHash)
+	buf := &bytes.Buffer{}
+	require.NoError(t, plan.Write(buf))
+	require.NotContains(t, buf.String(), "secret value")
+
+	values := SecretValues{"TestSecret1": []byte("secret value 1"), "TestSecret2": []byte("secret value 2")}
+	secretsMock := SecretsProviderMock{
+		read: func(componentName, namespace string, secretNames SecretNames) (SecretValues, error) {
+			return values, nil
+		},
+	}
+
+	planned, err := plan.Changes(cur, secretsMock)
+	require.NoError(t, err)
+	require.NoError(t, executor.CheckAdoptions(planned))
+
+	// a secret was rotated after the plan was made
+	values = SecretValues{"TestSecret1": []byte("secret value 1"), "TestSecret2": []byte("rotated")}
+	_, err = plan.Changes(cur, secretsMock)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "secret values of `new-one` changed since the plan was made")
+	values = SecretValues{"TestSecret1": []byte("secret value 1"), "TestSecret2": []byte("secret value 2")}
+
+	// the plan file was edited to adopt another release
+	plan.Adopt = []string{"someone-elses"}
+	_, err = plan.Changes(cur, secretsMock)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "the releases to adopt differ")
+	plan.Adopt = nil
+
+	// a release with the name of a component was installed by hand after the plan was made
+	releases = []*release.Release{{Name: "new-one", Namespace: nu.Namespace, Chart: &chart.Chart{Metadata: &chart.Metadata{Name: "connector-hdfs", Version: "0.1.0"}}, Config: &chart.Config{Raw: "FlushSize: 1\n"}}}
+	err = executor.CheckAdoptions(planned)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "release `new-one` was installed outside landscaper since")
+
+	// and the other way around: the release to adopt is gone
+	planned.Update, planned.Create = planned.Create, Components{}
+	planned.Adopt["new-one"] = true
+	releases = nil
+	err = executor.CheckAdoptions(planned)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "release `new-one` no longer needs to be adopted")
+}
+
+func TestReadPlanVersion(t *testing.T) {
+	_, err := ReadPlan(strings.NewReader(`{"version": 42}`))
+	require.Error(t, err)
+
+	_, err = ReadPlan(strings.NewReader(`{"version": 1, "create": [], "update": [], "delete": []}`))
+	require.NoError(t, err)
+}
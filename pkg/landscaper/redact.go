@@ -0,0 +1,345 @@
+package landscaper
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRedactKeys are the patterns of the keys whose values are redacted, unless others are configured
+var DefaultRedactKeys = []string{"password", "token", "key", "secret"}
+
+const (
+	redacted        = "<redacted>"
+	redactedChanged = "<redacted, changed>"
+
+	// minRedactedLength is the length from which secret values are also masked inside longer texts, such as log
+	// messages; shorter values are only masked where a whole value equals them
+	minRedactedLength = 4
+)
+
+// redactor masks the values of keys that match its patterns, and the secret values it has seen, in logs and diffs
+type redactor struct {
+	mu      sync.RWMutex
+	keys    []*regexp.Regexp
+	secrets map[string]bool
+}
+
+// redaction is the redactor that every log entry and diff passes through
+var redaction = newRedactor()
+
+func newRedactor() *redactor {
+	r := &redactor{secrets: map[string]bool{}}
+	if err := r.setKeys(DefaultRedactKeys); err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// SetRedactKeys replaces the patterns of the keys whose values are redacted in logs and diffs. Patterns are regular
+// expressions that are matched case-insensitively against every key of a value's path, e.g. `password` matches
+// db.adminPassword.
+func SetRedactKeys(patterns []string) error {
+	return redaction.setKeys(patterns)
+}
+
+// RedactionHook returns a logrus hook that redacts the messages and fields of all log entries
+func RedactionHook() logrus.Hook {
+	return redaction
+}
+
+func (r *redactor) setKeys(patterns []string) error {
+	keys := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return fmt.Errorf("redact key pattern `%s` is not a valid regular expression: %s", pattern, err)
+		}
+		keys = append(keys, re)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = keys
+	return nil
+}
+
+// addSecretValues makes sure values are masked wherever they show up
+func (r *redactor) addSecretValues(values SecretValues) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range values {
+		if len(v) > 0 {
+			r.secrets[string(v)] = true
+		}
+	}
+}
+
+// sensitiveKey tells whether the value of key is redacted. The keys that landscaper adds for its own use never are.
+func (r *redactor) sensitiveKey(key string) bool {
+	for _, k := range internalKeys {
+		if key == k {
+			return false
+		}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, re := range r.keys {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitivePath tells whether one of the keys of path, as shown in diffs, is sensitive
+func (r *redactor) sensitivePath(path string) bool {
+	for _, key := range pathKeys(path) {
+		if r.sensitiveKey(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecret tells whether v equals a secret value
+func (r *redactor) isSecret(v interface{}) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.secrets[s]
+}
+
+// text masks the secret values in s
+func (r *redactor) text(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.secrets[s] {
+		return redacted
+	}
+	for secret := range r.secrets {
+		if len(secret) >= minRedactedLength && strings.Contains(s, secret) {
+			s = strings.Replace(s, secret, redacted, -1)
+		}
+	}
+	return s
+}
+
+// valueChange masks the values of vc, when its path is sensitive or they are secret. A masked value that changed is
+// shown as such.
+func (r *redactor) valueChange(vc *ValueChange) *ValueChange {
+	sensitive := r.sensitivePath(vc.Path)
+	mask := func(v interface{}) interface{} {
+		if v != nil && (sensitive || r.isSecret(v)) {
+			return redacted
+		}
+		if s, ok := v.(string); ok {
+			return r.text(s)
+		}
+		return v
+	}
+
+	masked := &ValueChange{Path: vc.Path, Kind: vc.Kind, Current: mask(vc.Current), Desired: mask(vc.Desired)}
+	if vc.Kind == ValueChanged && masked.Desired == redacted {
+		masked.Desired = redactedChanged
+	}
+	return masked
+}
+
+// tree returns a copy of v with the values of sensitive keys and the secret values masked. Where other, the
+// counterpart of v on the other side of a diff, holds a different value, the mask says it changed.
+func (r *redactor) tree(v, other interface{}) interface{} {
+	switch t := v.(type) {
+	case Configuration:
+		o, _ := other.(Configuration)
+		return Configuration(r.tree(map[string]interface{}(t), map[string]interface{}(o)).(map[string]interface{}))
+	case map[string]interface{}:
+		o, _ := other.(map[string]interface{})
+		masked := map[string]interface{}{}
+		for k, sub := range t {
+			ov, inOther := o[k]
+			if r.sensitiveKey(k) {
+				masked[k] = redacted
+				if inOther && !reflect.DeepEqual(sub, ov) {
+					masked[k] = redactedChanged
+				}
+				continue
+			}
+			masked[k] = r.tree(sub, ov)
+		}
+		return masked
+	case []interface{}:
+		o, _ := other.([]interface{})
+		masked := make([]interface{}, len(t))
+		for i, sub := range t {
+			var ov interface{}
+			if i < len(o) {
+				ov = o[i]
+			}
+			masked[i] = r.tree(sub, ov)
+		}
+		return masked
+	case string:
+		return r.text(t)
+	}
+	return v
+}
+
+// manifest returns a copy of v, the content of a rendered Kubernetes object or part of it, with the secret values
+// masked. The keys of an object are Kubernetes' own, so they aren't matched against the patterns; only the values of
+// the environment variables whose names match are masked. other is the counterpart of v on the other side of a diff.
+func (r *redactor) manifest(v, other interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		o, _ := other.(map[string]interface{})
+		masked := map[string]interface{}{}
+		for k, sub := range t {
+			if k == "env" {
+				masked[k] = r.env(sub, o[k])
+				continue
+			}
+			masked[k] = r.manifest(sub, o[k])
+		}
+		return masked
+	case []interface{}:
+		o, _ := other.([]interface{})
+		masked := make([]interface{}, len(t))
+		for i, sub := range t {
+			var ov interface{}
+			if i < len(o) {
+				ov = o[i]
+			}
+			masked[i] = r.manifest(sub, ov)
+		}
+		return masked
+	case string:
+		return r.text(t)
+	}
+	return v
+}
+
+// env masks a container's environment variables like manifest, and the values of those whose names are sensitive.
+// Variables are matched with their counterparts in other by name.
+func (r *redactor) env(v, other interface{}) interface{} {
+	vars, ok := v.([]interface{})
+	if !ok {
+		return r.manifest(v, other)
+	}
+	others := map[string]interface{}{}
+	if o, ok := other.([]interface{}); ok {
+		for _, ov := range o {
+			if m, ok := ov.(map[string]interface{}); ok {
+				if name, ok := m["name"].(string); ok {
+					others[name] = m
+				}
+			}
+		}
+	}
+
+	masked := make([]interface{}, len(vars))
+	for i, sub := range vars {
+		m, ok := sub.(map[string]interface{})
+		name, _ := m["name"].(string)
+		if !ok || name == "" {
+			masked[i] = r.manifest(sub, nil)
+			continue
+		}
+		om, _ := others[name].(map[string]interface{})
+		mm := r.manifest(m, om).(map[string]interface{})
+		if value, ok := m["value"]; ok && r.sensitiveKey(name) {
+			mm["value"] = redacted
+			if ov, ok := om["value"]; ok && !reflect.DeepEqual(value, ov) {
+				mm["value"] = redactedChanged
+			}
+		}
+		masked[i] = mm
+	}
+	return masked
+}
+
+// Levels are all levels; every log entry is redacted
+func (r *redactor) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire masks the secret values in the message and fields of entry, and the values of sensitive keys in fields such as
+// a component's configuration. A component itself is logged by its name. The names of the fields are landscaper's own,
+// so they aren't matched. The fields are replaced rather than changed, since the caller may still use them.
+func (r *redactor) Fire(entry *logrus.Entry) error {
+	entry.Message = r.text(entry.Message)
+
+	data := logrus.Fields{}
+	for k, v := range entry.Data {
+		switch t := v.(type) {
+		case *Component:
+			v = t.Name // never its configuration or secret values
+		case Component:
+			v = t.Name
+		case Configuration, map[string]interface{}, []interface{}, string:
+			v = r.tree(t, nil)
+		case error:
+			v = r.text(t.Error())
+		}
+		data[k] = v
+	}
+	entry.Data = data
+	return nil
+}
+
+// pathKeys splits a value path as shown in diffs, such as image.tag or podAnnotations["example.com/x"], into its keys.
+// List indexes are left out.
+func pathKeys(path string) []string {
+	keys := []string{}
+	for len(path) > 0 {
+		switch {
+		case strings.HasPrefix(path, `["`):
+			end := quotedKeyEnd(path)
+			if end < 0 {
+				return append(keys, path)
+			}
+			key, _ := strconv.Unquote(path[1:end])
+			keys = append(keys, key)
+			path = path[end+1:]
+		case strings.HasPrefix(path, "["):
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return keys
+			}
+			path = path[end+1:]
+		case strings.HasPrefix(path, "."):
+			path = path[1:]
+		default:
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			keys = append(keys, path[:end])
+			path = path[end:]
+		}
+	}
+	return keys
+}
+
+// quotedKeyEnd returns the index of the closing bracket of the quoted key that path starts with, or -1
+func quotedKeyEnd(path string) int {
+	for i := 2; i < len(path); i++ {
+		if path[i] != ']' || path[i-1] != '"' {
+			continue
+		}
+		if _, err := strconv.Unquote(path[1:i]); err == nil {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,170 @@
+package landscaper
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+func TestPathKeys(t *testing.T) {
+	require.Equal(t, []string{"image", "tag"}, pathKeys("image.tag"))
+	require.Equal(t, []string{"args"}, pathKeys("args[1]"))
+	require.Equal(t, []string{"sidecars", "env", "name"}, pathKeys("sidecars[0].env[2].name"))
+	require.Equal(t, []string{"podAnnotations", "example.com/x", "y"}, pathKeys(`podAnnotations["example.com/x"].y`))
+	require.Equal(t, []string{"a", `b"]c`}, pathKeys(`a["b\"]c"]`))
+}
+
+func TestRedactorValues(t *testing.T) {
+	r := newRedactor()
+	r.addSecretValues(SecretValues{"DB_PASSWORD": []byte("hunter22"), "PIN": []byte("42")})
+
+	require.Equal(t, "connect with <redacted>", r.text("connect with hunter22"))
+	require.Equal(t, "<redacted>", r.text("42"))
+	require.Equal(t, "answer 42", r.text("answer 42")) // too short to mask inside a text
+
+	require.Equal(t, &ValueChange{Path: "db.adminPassword", Kind: ValueChanged, Current: redacted, Desired: redactedChanged},
+		r.valueChange(&ValueChange{Path: "db.adminPassword", Kind: ValueChanged, Current: "a", Desired: "b"}))
+	require.Equal(t, &ValueChange{Path: "db.url", Kind: ValueAdded, Desired: "postgres://app:<redacted>@db"},
+		r.valueChange(&ValueChange{Path: "db.url", Kind: ValueAdded, Desired: "postgres://app:hunter22@db"}))
+	require.Equal(t, &ValueChange{Path: "replicas", Kind: ValueRemoved, Current: float64(2)},
+		r.valueChange(&ValueChange{Path: "replicas", Kind: ValueRemoved, Current: float64(2)}))
+
+	cur := Configuration{"apiToken": "abc", "tls": map[string]interface{}{"privateKey": "k"}, "secretsRef": "ns-web", "user": "42"}
+	des := Configuration{"apiToken": "abd", "tls": map[string]interface{}{"privateKey": "k"}, "secretsRef": "ns-web", "user": "42"}
+	require.Equal(t, Configuration{
+		"apiToken":   redactedChanged,
+		"tls":        map[string]interface{}{"privateKey": redacted},
+		"secretsRef": "ns-web",
+		"user":       redacted,
+	}, r.tree(des, cur))
+	require.Equal(t, "abc", cur["apiToken"]) // not changed in place
+
+	require.NoError(t, r.setKeys([]string{"^pass"}))
+	require.Equal(t, "abd", r.tree(des, nil).(Configuration)["apiToken"])
+	require.Error(t, r.setKeys([]string{"("}))
+}
+
+func TestRedactorManifest(t *testing.T) {
+	r := newRedactor()
+	r.addSecretValues(SecretValues{"DB_PASSWORD": []byte("hunter22")})
+
+	container := func(password string) map[string]interface{} {
+		return map[string]interface{}{
+			"args": []interface{}{"--dsn=postgres://app:" + password + "@db"},
+			"env": []interface{}{
+				map[string]interface{}{"name": "LOG_LEVEL", "value": "debug"},
+				map[string]interface{}{"name": "DB_PASSWORD", "value": password},
+				map[string]interface{}{"name": "API_TOKEN", "valueFrom": map[string]interface{}{"secretKeyRef": map[string]interface{}{"name": "api", "key": "token"}}},
+			},
+		}
+	}
+	object := func(password string) map[string]interface{} {
+		return map[string]interface{}{
+			"kind": "Deployment",
+			"spec": map[string]interface{}{
+				"containers": []interface{}{container(password)},
+				"volumes":    []interface{}{map[string]interface{}{"name": "tls", "secret": map[string]interface{}{"secretName": "web-tls"}}},
+			},
+		}
+	}
+
+	// the keys of the object are left alone, even where they match a pattern
+	masked := r.manifest(object("hunter22"), object("hunter11"))
+	require.Equal(t, map[string]interface{}{
+		"kind": "Deployment",
+		"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{
+				"args": []interface{}{"--dsn=postgres://app:<redacted>@db"},
+				"env": []interface{}{
+					map[string]interface{}{"name": "LOG_LEVEL", "value": "debug"},
+					map[string]interface{}{"name": "DB_PASSWORD", "value": redactedChanged},
+					map[string]interface{}{"name": "API_TOKEN", "valueFrom": map[string]interface{}{"secretKeyRef": map[string]interface{}{"name": "api", "key": "token"}}},
+				},
+			}},
+			"volumes": []interface{}{map[string]interface{}{"name": "tls", "secret": map[string]interface{}{"secretName": "web-tls"}}},
+		},
+	}, masked)
+	require.Equal(t, redacted, r.manifest(container("hunter22"), container("hunter22")).(map[string]interface{})["env"].([]interface{})[1].(map[string]interface{})["value"])
+}
+
+func TestRedactionHook(t *testing.T) {
+	r := newRedactor()
+	r.addSecretValues(SecretValues{"DB_PASSWORD": []byte("hunter22")})
+
+	buf := &bytes.Buffer{}
+	log := logrus.New()
+	log.Out = buf
+	log.Formatter = &logrus.TextFormatter{DisableColors: true, DisableTimestamp: true}
+	log.Hooks.Add(r)
+
+	values := Configuration{"password": "plain", "replicas": float64(2)}
+	log.WithFields(logrus.Fields{
+		"values": values,
+		"error":  errors.New("login with hunter22 failed"),
+	}).Infof("password is %s", "hunter22")
+
+	out := buf.String()
+	require.Contains(t, out, `msg="password is <redacted>"`)
+	require.Contains(t, out, `password:<redacted>`)
+	require.Contains(t, out, `replicas:2`)
+	require.Contains(t, out, `error="login with <redacted> failed"`)
+	require.NotContains(t, out, "hunter22")
+	require.NotContains(t, out, "plain")
+	require.Equal(t, "plain", values["password"])
+}
+
+func TestExecutorLogsFailingComponentRedacted(t *testing.T) {
+	std := logrus.StandardLogger()
+	defer func(out io.Writer, hooks logrus.LevelHooks, formatter logrus.Formatter) {
+		std.Out, std.Hooks, std.Formatter = out, hooks, formatter
+	}(std.Out, std.Hooks, std.Formatter)
+	buf := &bytes.Buffer{}
+	std.Out, std.Hooks = buf, logrus.LevelHooks{}
+	std.Formatter = &logrus.TextFormatter{DisableColors: true, DisableTimestamp: true}
+	std.Hooks.Add(newRedactor())
+
+	cmp := newTestComponent("web")
+	cmp.SecretValues = SecretValues{}
+	cmp.Configuration["db"] = map[string]interface{}{"password": "plain-password"}
+	helmMock := &HelmclientMock{
+		installRelease: func(chStr string, namespace string, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+			return nil, errors.New("chart is broken")
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/opt/store/whatever/path/", nil
+	})
+
+	_, err := NewExecutor(helmMock, chartLoadMock, SecretsProviderMock{}, false, false, waitTimeout, disabledStages).Apply(Components{cmp.Name: cmp}, Components{})
+	require.Error(t, err)
+
+	out := buf.String()
+	require.Contains(t, out, "CreateComponent failed")
+	require.Contains(t, out, "component=web")
+	require.NotContains(t, out, "plain-password")
+
+	// a component given as a field is logged by its name
+	buf.Reset()
+	std.WithFields(logrus.Fields{"component": cmp}).Error("failed")
+	require.Contains(t, buf.String(), "component=web")
+	require.NotContains(t, buf.String(), "plain-password")
+}
+
+func TestDiffComponentsRedacts(t *testing.T) {
+	cur := newTestComponent("web")
+	cur.Configuration["db"] = map[string]interface{}{"password": "before", "host": "db-1"}
+	des := newTestComponent("web")
+	des.Configuration["db"] = map[string]interface{}{"password": "after", "host": "db-2"}
+
+	require.Equal(t, []string{
+		"  ~ db.host: db-1 → db-2",
+		"  ~ db.password: <redacted> → <redacted, changed>",
+	}, diffComponents(cur, des).Lines())
+}
@@ -0,0 +1,144 @@
+package landscaper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// The reasons for deleting and creating a component instead of updating it
+const (
+	ForcedBySecrets   = "secret values changed" // pods only pick up new secret values when they are recreated; followed by the keys
+	ForcedByNamespace = "namespace changed"     // Helm cannot move a release to another namespace
+)
+
+// ApplyResult is the outcome of applying changes, with an entry per component that was acted upon or skipped
+type ApplyResult struct {
+	Components []*ComponentResult `json:"components"`
+}
+
+// ComponentResult is the outcome of a single action on a component
+type ComponentResult struct {
+	Component       string  `json:"component"`
+	Action          string  `json:"action"` // create, update, delete or rollback
+	Forced          bool    `json:"forced,omitempty"`
+	ForcedReason    string  `json:"forcedReason,omitempty"`
+	Adopted         bool    `json:"adopted,omitempty"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	RevisionBefore  int32   `json:"revisionBefore,omitempty"` // the Helm revision before the action; 0 if there was no release
+	RevisionAfter   int32   `json:"revisionAfter,omitempty"`  // the Helm revision after the action; 0 if there is no release
+	Error           string  `json:"error,omitempty"`
+	SkippedReason   string  `json:"skippedReason,omitempty"`
+	BlockedReason   string  `json:"blockedReason,omitempty"` // why the action was refused; the component was left alone
+}
+
+// Status summarizes the outcome of the action: ok, failed, skipped or blocked
+func (r *ComponentResult) Status() string {
+	switch {
+	case r.BlockedReason != "":
+		return "blocked"
+	case r.SkippedReason != "":
+		return "skipped"
+	case r.Error != "":
+		return "failed"
+	}
+	return "ok"
+}
+
+func (r *ComponentResult) setDuration(d time.Duration) {
+	r.DurationSeconds = d.Seconds()
+}
+
+// Succeeded returns the names of the components the action succeeded for, in the order they were handled
+func (r *ApplyResult) Succeeded(action string) []string {
+	names := []string{}
+	for _, cr := range r.Components {
+		if cr.Action == action && cr.Status() == "ok" {
+			names = append(names, cr.Component)
+		}
+	}
+	return names
+}
+
+// revisionAfter returns the revision the named component ended up with after the given action
+func (r *ApplyResult) revisionAfter(name, action string) int32 {
+	for _, cr := range r.Components {
+		if cr.Component == name && cr.Action == action {
+			return cr.RevisionAfter
+		}
+	}
+	return 0
+}
+
+// Blocked returns the names of the components whose action was refused
+func (r *ApplyResult) Blocked() []string {
+	names := []string{}
+	for _, cr := range r.Components {
+		if cr.Status() == "blocked" {
+			names = append(names, cr.Component)
+		}
+	}
+	return names
+}
+
+// Skipped returns the names of the components that were skipped
+func (r *ApplyResult) Skipped() []string {
+	names := []string{}
+	for _, cr := range r.Components {
+		if cr.Status() == "skipped" {
+			names = append(names, cr.Component)
+		}
+	}
+	return names
+}
+
+// WriteJSON writes the result as indented JSON
+func (r *ApplyResult) WriteJSON(w io.Writer) error {
+	bs, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bs, '\n'))
+	return err
+}
+
+// WriteTable writes the result as a table with a row per component action
+func (r *ApplyResult) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMPONENT\tACTION\tSTATUS\tREVISION\tDURATION\tDETAILS")
+	for _, cr := range r.Components {
+		action := cr.Action
+		if cr.Adopted {
+			action = "adopt"
+		} else if cr.Forced && (cr.Action == "delete" || cr.Action == "create") {
+			action = fmt.Sprintf("replace (%s)", cr.Action)
+		}
+
+		details := cr.SkippedReason
+		if cr.Error != "" {
+			details = cr.Error
+		} else if cr.BlockedReason != "" {
+			details = cr.BlockedReason
+		} else if cr.Forced {
+			details = "forced: " + cr.ForcedReason
+		}
+
+		revision := ""
+		if cr.RevisionBefore != 0 || cr.RevisionAfter != 0 {
+			revision = fmt.Sprintf("%s -> %s", revisionText(cr.RevisionBefore), revisionText(cr.RevisionAfter))
+		}
+
+		duration := time.Duration(cr.DurationSeconds * float64(time.Second)).Round(100 * time.Millisecond)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", cr.Component, action, cr.Status(), revision, duration, details)
+	}
+	return tw.Flush()
+}
+
+func revisionText(revision int32) string {
+	if revision == 0 {
+		return "-"
+	}
+	return fmt.Sprint(revision)
+}
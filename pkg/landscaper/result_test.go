@@ -0,0 +1,38 @@
+package landscaper
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyResultWrite(t *testing.T) {
+	result := &ApplyResult{Components: []*ComponentResult{
+		{Component: "moved", Action: "delete", Forced: true, ForcedReason: ForcedByNamespace, RevisionBefore: 2, DurationSeconds: 0.5},
+		{Component: "upgraded", Action: "update", RevisionBefore: 3, RevisionAfter: 4, DurationSeconds: 61.25},
+		{Component: "broken", Action: "create", Error: "chart is broken"},
+		{Component: "dependant", Action: "create", SkippedReason: "`broken` failed"},
+	}}
+
+	require.Equal(t, []string{"upgraded"}, result.Succeeded("update"))
+	require.Equal(t, []string{"dependant"}, result.Skipped())
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, result.WriteTable(buf))
+	require.Equal(t, `COMPONENT  ACTION            STATUS   REVISION  DURATION  DETAILS
+moved      replace (delete)  ok       2 -> -    500ms     forced: namespace changed
+upgraded   update            ok       3 -> 4    1m1.3s    
+broken     create            failed             0s        chart is broken
+dependant  create            skipped            0s        `+"`broken`"+` failed
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, result.WriteJSON(buf))
+	decoded := &ApplyResult{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), decoded))
+	require.Equal(t, result, decoded)
+	require.Contains(t, buf.String(), `"revisionBefore": 3`)
+	require.NotContains(t, buf.String(), `"skippedReason": ""`)
+}
@@ -0,0 +1,164 @@
+package landscaper
+
+import (
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// RetryPolicy tells how often and how long to retry calls to Tiller and Kubernetes that fail with a transient error.
+// The delay doubles with every attempt, starting at BaseDelay and capped at MaxDelay.
+type RetryPolicy struct {
+	Attempts  int           // the maximum number of attempts; 1 or less doesn't retry
+	BaseDelay time.Duration // the delay before the first retry
+	MaxDelay  time.Duration // the maximum delay between attempts; 0 means no maximum
+}
+
+// retryingHelmClient is a helm.Interface that retries the reads landscaper makes. Calls that change a release aren't
+// retried here, since a lost response doesn't tell whether Tiller acted; the Executor retries those after checking the
+// state of the release (see RetryPolicy.doChecked).
+type retryingHelmClient struct {
+	helm.Interface
+	policy RetryPolicy
+}
+
+// retryingSecrets is a SecretsReadWriteDeleter that retries its calls
+type retryingSecrets struct {
+	secrets SecretsReadWriteDeleter
+	policy  RetryPolicy
+}
+
+// NewRetryingHelmClient wraps helmClient such that reads failing with a transient error are retried according to policy
+func NewRetryingHelmClient(helmClient helm.Interface, policy RetryPolicy) helm.Interface {
+	return &retryingHelmClient{helmClient, policy}
+}
+
+// NewRetryingSecrets wraps secrets such that calls failing with a transient error are retried according to policy
+func NewRetryingSecrets(secrets SecretsReadWriteDeleter, policy RetryPolicy) SecretsReadWriteDeleter {
+	return &retryingSecrets{secrets, policy}
+}
+
+// do calls fn until it succeeds, fails with a permanent error, or runs out of attempts. It returns the last error.
+func (p RetryPolicy) do(operation string, fn func() error) error {
+	return p.doChecked(operation, fn, nil)
+}
+
+// doChecked is do for a call that changes state. Before every retry, applied is asked whether the failed call took
+// effect after all, e.g. because only the response was lost. If so, doChecked succeeds without calling fn again; if
+// applied fails, that error is returned. A nil applied retries unconditionally.
+func (p RetryPolicy) doChecked(operation string, fn func() error, applied func() (bool, error)) error {
+	delay := p.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || !isTransient(err) {
+			return err
+		}
+
+		log := logrus.WithFields(logrus.Fields{"operation": operation, "attempt": attempt, "attempts": p.Attempts, "delay": delay, "error": err})
+		time.Sleep(delay)
+
+		if applied != nil {
+			done, checkErr := applied()
+			if checkErr != nil {
+				return checkErr
+			}
+			if done {
+				log.Warn("Transient error, but the call took effect")
+				return nil
+			}
+		}
+		log.Warn("Transient error; retrying")
+
+		delay *= 2
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// isTransient tells whether err may well go away by itself, such as an unavailable Tiller or a conflicting write.
+// Errors reported by Tiller about the request itself, such as chart render errors, are permanent.
+func isTransient(err error) bool {
+	switch grpc.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+
+	if apierrors.IsConflict(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) || apierrors.IsServiceUnavailable(err) {
+		return true
+	}
+
+	if netErr, ok := err.(net.Error); ok {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+
+	return false
+}
+
+func (c *retryingHelmClient) ListReleases(opts ...helm.ReleaseListOption) (res *services.ListReleasesResponse, err error) {
+	err = c.policy.do("ListReleases", func() error {
+		res, err = c.Interface.ListReleases(opts...)
+		return err
+	})
+	return res, err
+}
+
+func (c *retryingHelmClient) ReleaseContent(rlsName string, opts ...helm.ContentOption) (res *services.GetReleaseContentResponse, err error) {
+	err = c.policy.do("ReleaseContent", func() error {
+		res, err = c.Interface.ReleaseContent(rlsName, opts...)
+		return err
+	})
+	return res, err
+}
+
+func (c *retryingHelmClient) ReleaseHistory(rlsName string, opts ...helm.HistoryOption) (res *services.GetHistoryResponse, err error) {
+	err = c.policy.do("ReleaseHistory", func() error {
+		res, err = c.Interface.ReleaseHistory(rlsName, opts...)
+		return err
+	})
+	return res, err
+}
+
+func (s *retryingSecrets) Read(componentName, namespace string, secretNames SecretNames) (values SecretValues, err error) {
+	err = s.policy.do("ReadSecrets", func() error {
+		values, err = s.secrets.Read(componentName, namespace, secretNames)
+		return err
+	})
+	return values, err
+}
+
+// Write creates the secrets, which fails if they exist. When a write fails with a transient error, the secrets may have
+// been created all the same, so it only retries when they don't hold secretValues yet.
+func (s *retryingSecrets) Write(componentName, namespace string, secretValues SecretValues) error {
+	written := func() (bool, error) {
+		values, err := s.secrets.Read(componentName, namespace, nil)
+		if err != nil {
+			return false, err
+		}
+		return len(values) > 0 && reflect.DeepEqual(values, secretValues), nil
+	}
+	return s.policy.doChecked("WriteSecrets", func() error {
+		return s.secrets.Write(componentName, namespace, secretValues)
+	}, written)
+}
+
+func (s *retryingSecrets) Delete(componentName, namespace string) error {
+	return s.policy.do("DeleteSecrets", func() error {
+		return s.secrets.Delete(componentName, namespace)
+	})
+}
+
+// Source tells where a secret is kept, if the wrapped provider can tell
+func (s *retryingSecrets) Source(componentName, namespace, reference string) string {
+	if source, ok := s.secrets.(SecretsSource); ok {
+		return source.Source(componentName, namespace, reference)
+	}
+	return ""
+}
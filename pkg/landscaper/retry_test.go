@@ -0,0 +1,185 @@
+package landscaper
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+func TestRetryingHelmClient(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+	var calls, updates int
+	var errs []error
+	unavailable := grpc.Errorf(codes.Unavailable, "transport is closing")
+	helmMock := &HelmclientMock{
+		listReleases: func(opts ...helm.ReleaseListOption) (*services.ListReleasesResponse, error) {
+			err := errs[calls]
+			calls++
+			if err != nil {
+				return nil, err
+			}
+			return &services.ListReleasesResponse{}, nil
+		},
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			updates++
+			return nil, unavailable
+		},
+	}
+	client := NewRetryingHelmClient(helmMock, policy)
+
+	calls, errs = 0, []error{unavailable, unavailable, nil}
+	res, err := client.ListReleases()
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	require.Equal(t, 3, calls)
+
+	calls, errs = 0, []error{unavailable, unavailable, unavailable, nil}
+	_, err = client.ListReleases()
+	require.Equal(t, unavailable, err)
+	require.Equal(t, 3, calls)
+
+	renderErr := grpc.Errorf(codes.Unknown, "render error in \"chart/templates/deployment.yaml\"")
+	calls, errs = 0, []error{renderErr, nil}
+	_, err = client.ListReleases()
+	require.Equal(t, renderErr, err)
+	require.Equal(t, 1, calls)
+
+	calls, errs = 0, []error{unavailable, nil}
+	_, err = NewRetryingHelmClient(helmMock, RetryPolicy{Attempts: 1}).ListReleases()
+	require.Equal(t, unavailable, err)
+	require.Equal(t, 1, calls)
+
+	// writes are left to the Executor, which checks the release before retrying
+	_, err = client.UpdateRelease("rls", "chart")
+	require.Equal(t, unavailable, err)
+	require.Equal(t, 1, updates)
+}
+
+func TestExecutorRetriesWrites(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond}
+	unavailable := grpc.Errorf(codes.Unavailable, "transport is closing")
+	cmp := newTestComponent("web")
+	cmp.SecretValues = nil
+
+	var history []*release.Release
+	revision := func(version int32, status release.Status_Code) *release.Release {
+		return &release.Release{Name: "web", Version: version, Info: &release.Info{Status: &release.Status{Code: status}}}
+	}
+
+	var updates int
+	var updateErrs []error
+	helmMock := &HelmclientMock{
+		updateRelease: func(rlsName string, chStr string, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
+			err := updateErrs[updates]
+			updates++
+			if err == unavailable && len(history) == 2 {
+				history = append(history, revision(3, release.Status_DEPLOYED)) // Tiller upgraded, but the response got lost
+			}
+			if err != nil {
+				return nil, err
+			}
+			return &services.UpdateReleaseResponse{Release: revision(int32(len(history)+1), release.Status_DEPLOYED)}, nil
+		},
+		releaseHistory: func(rlsName string, opts ...helm.HistoryOption) (*services.GetHistoryResponse, error) {
+			if len(history) == 0 {
+				return nil, grpc.Errorf(codes.Unknown, "release: %q not found", rlsName)
+			}
+			return &services.GetHistoryResponse{Releases: []*release.Release{history[len(history)-1]}}, nil
+		},
+	}
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return nil, "/charts/web", nil
+	})
+	secretsMock := SecretsProviderMock{delete: func(componentName, namespace string) error { return nil }}
+	e := NewExecutor(helmMock, chartLoadMock, secretsMock, false, false, 0, nil, WithRetry(policy)).(*executor)
+
+	// the lost response is not repeated
+	history, updates, updateErrs = []*release.Release{revision(1, release.Status_SUPERSEDED), revision(2, release.Status_DEPLOYED)}, 0, []error{unavailable, nil}
+	rev, err := e.updateComponent(cmp, logrus.StandardLogger())
+	require.NoError(t, err)
+	require.Equal(t, int32(3), rev)
+	require.Equal(t, 1, updates)
+
+	// the call that didn't reach Tiller is
+	history, updates, updateErrs = []*release.Release{revision(1, release.Status_DEPLOYED)}, 0, []error{unavailable, nil}
+	rev, err = e.updateComponent(cmp, logrus.StandardLogger())
+	require.NoError(t, err)
+	require.Equal(t, int32(2), rev)
+	require.Equal(t, 2, updates)
+
+	// an upgrade that is still going on is not retried
+	history, updates, updateErrs = []*release.Release{revision(1, release.Status_DEPLOYED)}, 0, []error{grpc.Errorf(codes.Aborted, "aborted"), nil}
+	helmMock.releaseHistory = func(rlsName string, opts ...helm.HistoryOption) (*services.GetHistoryResponse, error) {
+		if updates == 0 {
+			return &services.GetHistoryResponse{Releases: history}, nil
+		}
+		return &services.GetHistoryResponse{Releases: []*release.Release{revision(2, release.Status_PENDING_UPGRADE)}}, nil
+	}
+	_, err = e.updateComponent(cmp, logrus.StandardLogger())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "release `web` has revision 2 with status PENDING_UPGRADE after a failed UpdateRelease")
+	require.Equal(t, 1, updates)
+
+	// a delete whose response got lost is done once the release is gone
+	var deletes int
+	helmMock.deleteRelease = func(rlsName string, opts ...helm.DeleteOption) (*services.UninstallReleaseResponse, error) {
+		deletes++
+		history = nil
+		return nil, unavailable
+	}
+	helmMock.releaseHistory = func(rlsName string, opts ...helm.HistoryOption) (*services.GetHistoryResponse, error) {
+		return nil, grpc.Errorf(codes.Unknown, "release: %q not found", rlsName)
+	}
+	require.NoError(t, e.deleteComponent(cmp, logrus.StandardLogger()))
+	require.Equal(t, 1, deletes)
+}
+
+func TestRetryingSecrets(t *testing.T) {
+	conflict := apierrors.NewConflict(schema.GroupResource{Resource: "secrets"}, "cmp", errors.New("the object has been modified"))
+	exists := apierrors.NewAlreadyExists(schema.GroupResource{Resource: "secrets"}, "cmp")
+
+	timeout := apierrors.NewServerTimeout(schema.GroupResource{Resource: "secrets"}, "create", 1)
+
+	var writes int
+	var writeErrs []error
+	stored := SecretValues{}
+	secrets := NewRetryingSecrets(SecretsProviderMock{
+		write: func(componentName, namespace string, values SecretValues) error {
+			err := writeErrs[writes]
+			writes++
+			if err == nil || err == timeout {
+				stored = values
+			}
+			return err
+		},
+		read: func(componentName, namespace string, secretNames SecretNames) (SecretValues, error) {
+			return stored, nil
+		},
+	}, RetryPolicy{Attempts: 5, BaseDelay: time.Millisecond})
+	values := SecretValues{"password": []byte("s3cr3t")}
+
+	writes, writeErrs = 0, []error{conflict, conflict, nil}
+	require.NoError(t, secrets.Write("cmp", "ns", values))
+	require.Equal(t, 3, writes)
+
+	writes, writeErrs = 0, []error{exists, nil}
+	require.Equal(t, exists, secrets.Write("cmp", "ns", values))
+	require.Equal(t, 1, writes)
+
+	// the secrets were created although the response was lost, so creating them again would fail with AlreadyExists
+	writes, writeErrs, stored = 0, []error{timeout, exists}, SecretValues{}
+	require.NoError(t, secrets.Write("cmp", "ns", values))
+	require.Equal(t, 1, writes)
+}
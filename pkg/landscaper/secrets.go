@@ -38,6 +38,12 @@ type SecretsReadWriteDeleter interface {
 	SecretsWriteDeleter
 }
 
+// SecretsSource is implemented by secrets providers that can tell where a secret is kept, without revealing its value.
+// reference is the secret's reference from SecretNames, or its key if there is none.
+type SecretsSource interface {
+	Source(componentName, namespace, reference string) string
+}
+
 type kubeSecretsProvider struct {
 	kubeClient internalversion.CoreInterface
 }
@@ -84,6 +90,11 @@ func (sp *kubeSecretsProvider) Read(componentName, namespace string, secretNames
 	return secrets, nil
 }
 
+// Source tells which Kubernetes secret holds the secrets of the component
+func (sp *kubeSecretsProvider) Source(componentName, namespace, reference string) string {
+	return fmt.Sprintf("Kubernetes secret %s/%s", namespace, componentName)
+}
+
 func (sp *kubeSecretsProvider) Write(componentName, namespace string, secrets SecretValues) error {
 	logrus.WithFields(logrus.Fields{"component": componentName, "namespace": namespace}).Info("Writing secrets for component")
 
@@ -160,7 +171,7 @@ func (sp *kubeSecretsProvider) ensureNamespace(namespace string) error {
 func (env *environmentSecrets) Read(componentName, namespace string, secretNames SecretNames) (SecretValues, error) {
 	secs := SecretValues{}
 	for key, value := range secretNames {
-		envName := strings.Replace(strings.ToUpper(value), "-", "_", -1)
+		envName := secretEnvName(value)
 
 		secretValue := os.Getenv(envName)
 		if len(secretValue) == 0 {
@@ -172,3 +183,13 @@ func (env *environmentSecrets) Read(componentName, namespace string, secretNames
 	}
 	return secs, nil
 }
+
+// Source tells which environment variable holds the referenced secret
+func (env *environmentSecrets) Source(componentName, namespace, reference string) string {
+	return fmt.Sprintf("environment variable %s", secretEnvName(reference))
+}
+
+// secretEnvName returns the name of the environment variable for the referenced secret
+func secretEnvName(reference string) string {
+	return strings.Replace(strings.ToUpper(reference), "-", "_", -1)
+}
@@ -81,3 +81,8 @@ func (asp *azureSecretsReader) Read(componentName, namespace string, secretNames
 
 	return secrets, nil
 }
+
+// Source tells which key vault secret holds the referenced secret
+func (asp *azureSecretsReader) Source(componentName, namespace, reference string) string {
+	return fmt.Sprintf("Azure Key Vault %s, secret %s", asp.kvName, reference)
+}
@@ -38,6 +38,7 @@ type fileStateProvider struct {
 	namespace                 string
 	environment               string
 	configurationOverrideFile string
+	commit                    string // recorded in the metadata of the components, when they were read from git
 }
 
 type helmStateProvider struct {
@@ -48,7 +49,7 @@ type helmStateProvider struct {
 
 // NewFileStateProvider creates a StateProvider that sources Files
 func NewFileStateProvider(fileNames []string, secrets SecretsReader, chartLoader ChartLoader, releaseNamePrefix, namespace string, environment string, configurationOverrideFile string) StateProvider {
-	return &fileStateProvider{fileNames, secrets, chartLoader, releaseNamePrefix, namespace, environment, configurationOverrideFile}
+	return &fileStateProvider{fileNames, secrets, chartLoader, releaseNamePrefix, namespace, environment, configurationOverrideFile, ""}
 }
 
 // NewHelmStateProvider creates a StateProvider that sources Helm (actual state)
@@ -95,6 +96,8 @@ func (cp *helmStateProvider) Components() (Components, error) {
 		cmp.SecretValues = secretValues
 		cmp.SecretNames = SecretNames{}
 		cmp.SecretsRaw = nil
+		cmp.setSecretSources(cp.secrets)
+		redaction.addSecretValues(cmp.SecretValues)
 
 		components[cmp.Name] = cmp
 	}
@@ -106,37 +109,33 @@ func (cp *helmStateProvider) Components() (Components, error) {
 
 // get loads the provided files. If the argument is a directory, *.yaml in it is loaded.
 func (cp *fileStateProvider) get(files []string) (Components, error) {
-	components := Components{}
-
 	logrus.WithFields(logrus.Fields{"files": files}).Info("Obtain desired state from files")
 
+	files, err := expandComponentFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	return cp.load(files, ioutil.ReadFile)
+}
+
+// load reads the component files with read, and turns them into the desired components
+func (cp *fileStateProvider) load(files []string, read func(filename string) ([]byte, error)) (Components, error) {
+	components := Components{}
+
 	for _, filename := range files {
-		fileInfo, err := os.Stat(filename)
+		logrus.WithFields(logrus.Fields{"file": filename}).Debug("Read desired state from file")
+		content, err := read(filename)
 		if err != nil {
-			return nil, err
-		}
-		if fileInfo.IsDir() {
-			logrus.WithFields(logrus.Fields{"file": filename}).Debugf("Crawl directory for *.yaml")
-			files, err := filepath.Glob(filepath.Join(filename, "*.yaml"))
-			if err != nil {
-				return nil, err
-			}
-			subComp, err := cp.get(files)
-			if err != nil {
-				return nil, err
-			}
-			for k, v := range subComp { // TODO: check for duplicate names
-				components[k] = v
-			}
-			continue
+			return nil, fmt.Errorf("failed to read `%s`: %s", filename, err)
 		}
-
-		logrus.WithFields(logrus.Fields{"file": filename}).Debug("Read desired state from file")
-		cmp, err := readComponentFromYAMLFilePath(filename)
+		cmp, err := newComponentFromYAML(content)
 		if err != nil {
-			return nil, fmt.Errorf("readComponentFromYAMLFilePath file `%s` failed: %s", filename, err)
+			return nil, fmt.Errorf("failed to parse `%s`: %s", filename, err)
+		}
+		if err := cp.normalizeFromFile(cmp); err != nil {
+			return nil, fmt.Errorf("failed to normalize `%s`: %s", filename, err)
 		}
-		cp.normalizeFromFile(cmp)
 
 		err = cp.coalesceComponent(cmp)
 		if err != nil {
@@ -149,6 +148,9 @@ func (cp *fileStateProvider) get(files []string) (Components, error) {
 				return nil, err
 			}
 			cmp.SecretValues = secr
+			cmp.setSecretsChecksum()
+			cmp.setSecretSources(cp.secrets)
+			redaction.addSecretValues(cmp.SecretValues)
 		}
 
 		if err := cmp.Validate(); err != nil {
@@ -160,7 +162,7 @@ func (cp *fileStateProvider) get(files []string) (Components, error) {
 			return nil, fmt.Errorf("duplicate component name `%s`", cmp.Name)
 		}
 
-		logrus.Debugf("desired %#v", *cmp)
+		logrus.WithFields(logrus.Fields{"component": cmp.Name, "values": cmp.Configuration}).Debug("Desired component")
 
 		components[cmp.Name] = cmp
 	}
@@ -174,6 +176,29 @@ func (cp *fileStateProvider) get(files []string) (Components, error) {
 	return components, nil
 }
 
+// expandComponentFiles replaces the directories in files by the *.yaml files in them
+func expandComponentFiles(files []string) ([]string, error) {
+	expanded := []string{}
+	for _, filename := range files {
+		fileInfo, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		if !fileInfo.IsDir() {
+			expanded = append(expanded, filename)
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{"file": filename}).Debugf("Crawl directory for *.yaml")
+		dirFiles, err := filepath.Glob(filepath.Join(filename, "*.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, dirFiles...)
+	}
+	return expanded, nil
+}
+
 // normalizeFromFile makes a Component look identical to a Component reconstructed from Helm
 func (cp *fileStateProvider) normalizeFromFile(c *Component) error {
 	c.Configuration["Name"] = c.Name
@@ -188,7 +213,14 @@ func (cp *fileStateProvider) normalizeFromFile(c *Component) error {
 	}
 	c.Release.Chart = ss[1]
 
-	c.Configuration.SetMetadata(&Metadata{ChartRepository: ss[0], ReleaseVersion: c.Release.Version})
+	// dependencies refer to components by their name in the files
+	var deps []string
+	for _, dep := range c.DependsOn {
+		deps = append(deps, cp.releaseNamePrefix+strings.ToLower(dep))
+	}
+	c.DependsOn = deps
+
+	c.Configuration.SetMetadata(&Metadata{ChartRepository: ss[0], ReleaseVersion: c.Release.Version, DependsOn: c.DependsOn, Protect: c.Protect, Labels: c.Labels, Commit: cp.commit, DeleteHooks: c.Hooks.forDelete()})
 
 	if c.Namespace == "" {
 		c.Namespace = cp.namespace
@@ -251,7 +283,14 @@ func newComponentFromYAML(content []byte) (*Component, error) {
 		cmp.SecretsRaw = nil
 	}
 
-	return NewComponent(cmp.Name, cmp.Namespace, cmp.Release, cmp.Configuration, cmp.Environments, cmp.SecretNames), nil
+	c := NewComponent(cmp.Name, cmp.Namespace, cmp.Release, cmp.Configuration, cmp.Environments, cmp.SecretNames)
+	c.DependsOn = cmp.DependsOn
+	c.Protect = cmp.Protect
+	c.Labels = cmp.Labels
+	c.Hooks = cmp.Hooks
+	c.Test = cmp.Test
+	c.IgnoreDifferences = cmp.IgnoreDifferences
+	return c, nil
 }
 
 // newConfigurationFromYAML parses a byteslice into a Component instance
@@ -311,7 +350,13 @@ func (cp *fileStateProvider) coalesceComponent(cmp *Component) error {
 		return err
 	}
 
+	defaults, err := chartutil.CoalesceValues(ch, &chart.Config{})
+	if err != nil {
+		return err
+	}
+
 	cmp.Configuration = Configuration(helmValues)
+	cmp.ChartDefaults = Configuration(defaults)
 
 	return nil
 }
@@ -355,18 +400,40 @@ func newComponentFromHelmRelease(release *release.Release) (*Component, error) {
 		Configurations{},
 		SecretNames{},
 	)
+	cmp.Revision = release.Version
+	cmp.Hooks = m.DeleteHooks
+
+	defaults, err := chartutil.CoalesceValues(release.Chart, &chart.Config{})
+	if err != nil {
+		return nil, err
+	}
+	cmp.ChartDefaults = Configuration(defaults)
 
 	return cmp, nil
 }
 
-// readComponentFromYAMLFilePath reads a yaml file from disk and returns an initialized Component
-func readComponentFromYAMLFilePath(filePath string) (*Component, error) {
-	cfg, err := ioutil.ReadFile(filePath)
+// newUnmanagedComponentFromHelmRelease creates a Component from a Release that is not controlled by landscaper.
+// Since Helm doesn't know where the chart came from, it is attributed to chartRepository.
+func newUnmanagedComponentFromHelmRelease(release *release.Release, chartRepository string) (*Component, error) {
+	cfg, err := getReleaseConfiguration(release)
 	if err != nil {
 		return nil, err
 	}
 
-	return newComponentFromYAML(cfg)
+	cfg.SetMetadata(&Metadata{ChartRepository: chartRepository})
+
+	cmp := NewComponent(
+		release.Name,
+		release.Namespace,
+		&Release{
+			Chart: fmt.Sprintf("%s:%s", release.Chart.Metadata.Name, release.Chart.Metadata.Version),
+		},
+		cfg,
+		Configurations{},
+		SecretNames{},
+	)
+
+	return cmp, nil
 }
 
 // readConfigurationFromYAMLFilePath reads a yaml file from disk and returns an initialized Component
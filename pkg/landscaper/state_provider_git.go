@@ -0,0 +1,139 @@
+package landscaper
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// GitStateProvider is a StateProvider that reads the desired state from a local git repository at a ref, without
+// checking it out
+type GitStateProvider interface {
+	StateProvider
+	Fetch() error             // fetches the remotes of the repository, so that remote-tracking refs can move
+	Resolve() (string, error) // resolves the ref to the commit that Components reads from, and returns that commit
+}
+
+type gitStateProvider struct {
+	*fileStateProvider
+	repo string
+	ref  string
+}
+
+// NewGitStateProvider creates a GitStateProvider that reads the component files from repo at ref. The file names are
+// paths in the repository; a directory means the *.yaml files in it. Without file names, the root of the repository is
+// read. The commit is recorded in the landscaper metadata of the components.
+func NewGitStateProvider(repo, ref string, fileNames []string, secrets SecretsReader, chartLoader ChartLoader, releaseNamePrefix, namespace string, environment string, configurationOverrideFile string) GitStateProvider {
+	if len(fileNames) == 0 {
+		fileNames = []string{"."}
+	}
+	return &gitStateProvider{
+		fileStateProvider: &fileStateProvider{fileNames, secrets, chartLoader, releaseNamePrefix, namespace, environment, configurationOverrideFile, ""},
+		repo:              repo,
+		ref:               ref,
+	}
+}
+
+// Fetch fetches all remotes of the repository
+func (gp *gitStateProvider) Fetch() error {
+	logrus.WithFields(logrus.Fields{"repo": gp.repo}).Debug("Fetch git repository")
+	_, err := gp.git("fetch", "--quiet", "--all")
+	return err
+}
+
+// Resolve resolves the ref to a commit, from which the following calls of Components read
+func (gp *gitStateProvider) Resolve() (string, error) {
+	out, err := gp.git("rev-parse", "--verify", "--quiet", gp.ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve git ref `%s` in `%s`: %s", gp.ref, gp.repo, err)
+	}
+	gp.commit = strings.TrimSpace(string(out))
+	return gp.commit, nil
+}
+
+// Components returns the desired components in the files at the resolved commit; the ref is resolved first if it
+// wasn't yet
+func (gp *gitStateProvider) Components() (Components, error) {
+	if gp.commit == "" {
+		if _, err := gp.Resolve(); err != nil {
+			return nil, err
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{"repo": gp.repo, "ref": gp.ref, "commit": gp.commit, "files": gp.fileNames}).Info("Obtain desired state from git")
+
+	files, err := gp.expandFiles(gp.fileNames)
+	if err != nil {
+		return nil, err
+	}
+
+	return gp.load(files, gp.readFile)
+}
+
+// expandFiles replaces the directories among files by the *.yaml files in them, at the resolved commit
+func (gp *gitStateProvider) expandFiles(files []string) ([]string, error) {
+	expanded := []string{}
+	for _, filename := range files {
+		p := gitPath(filename)
+		out, err := gp.git("cat-file", "-t", gp.object(p))
+		if err != nil {
+			return nil, fmt.Errorf("`%s` not found at commit %s", filename, gp.commit)
+		}
+		if strings.TrimSpace(string(out)) != "tree" {
+			expanded = append(expanded, p)
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{"file": filename}).Debugf("Crawl directory for *.yaml")
+		out, err = gp.git("ls-tree", gp.object(p))
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			// <mode> SP <type> SP <object> TAB <name>
+			fields := strings.SplitN(line, "\t", 2)
+			if len(fields) != 2 || !strings.Contains(fields[0], " blob ") {
+				continue
+			}
+			if ok, _ := filepath.Match("*.yaml", fields[1]); ok {
+				expanded = append(expanded, path.Join(p, fields[1]))
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// readFile returns the content of the file at the resolved commit
+func (gp *gitStateProvider) readFile(filename string) ([]byte, error) {
+	return gp.git("cat-file", "blob", gp.object(filename))
+}
+
+// object names the file or directory at p in the resolved commit
+func (gp *gitStateProvider) object(p string) string {
+	if p == "." {
+		p = ""
+	}
+	return gp.commit + ":" + p
+}
+
+// git runs a git command in the repository and returns its output
+func (gp *gitStateProvider) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", gp.repo}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("git %s failed: %s", args[0], err)
+	}
+	return out, nil
+}
+
+// gitPath turns a file name into a path in the repository
+func gitPath(filename string) string {
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(filename)), "/")
+}
@@ -0,0 +1,109 @@
+package landscaper
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+// gitTestRepo creates a git repository with the component files of the dependencies landscape in landscape/
+func gitTestRepo(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "landscaper-git")
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "landscape"), 0755))
+	for _, name := range []string{"database.yaml", "service.yaml"} {
+		content, err := ioutil.ReadFile(filepath.Join("../../test/landscapes/dependencies", name))
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "landscape", name), content, 0644))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "landscape", "README.md"), []byte("not a component"), 0644))
+
+	gitTestCommand(t, dir, "init", "--quiet")
+	gitTestCommit(t, dir, "Add landscape")
+	return dir
+}
+
+func gitTestCommand(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+func gitTestCommit(t *testing.T, dir, message string) {
+	gitTestCommand(t, dir, "add", "-A")
+	gitTestCommand(t, dir, "commit", "--quiet", "-m", message)
+}
+
+func TestGitStateProvider(t *testing.T) {
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		return &chart.Chart{Metadata: &chart.Metadata{Name: "hello-world", Version: "0.1.0"}, Values: &chart.Config{Raw: "message: xxx\n"}}, "", nil
+	})
+
+	repo := gitTestRepo(t)
+	defer os.RemoveAll(repo)
+
+	gs := NewGitStateProvider(repo, "HEAD", []string{"landscape/"}, SecretsProviderMock{}, chartLoadMock, "pfx-", "spa", "", "")
+	first, err := gs.Resolve()
+	require.NoError(t, err)
+	require.Len(t, first, 40)
+
+	cs, err := gs.Components()
+	require.NoError(t, err)
+	require.Len(t, cs, 2)
+	require.Equal(t, []string{"pfx-database"}, cs["pfx-service"].DependsOn)
+	m, err := cs["pfx-database"].Configuration.GetMetadata()
+	require.NoError(t, err)
+	require.Equal(t, first, m.Commit)
+
+	// the working tree doesn't matter; only what is committed
+	require.NoError(t, os.Remove(filepath.Join(repo, "landscape", "database.yaml")))
+	cs, err = gs.Components()
+	require.NoError(t, err)
+	require.Len(t, cs, 2)
+
+	// until the ref is resolved again, the provider sticks to the commit
+	gitTestCommit(t, repo, "Remove database")
+	cs, err = gs.Components()
+	require.NoError(t, err)
+	require.Len(t, cs, 2)
+
+	second, err := gs.Resolve()
+	require.NoError(t, err)
+	require.NotEqual(t, first, second)
+	_, err = gs.Components()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown component `pfx-database`")
+
+	// an older commit, and a single file
+	gs = NewGitStateProvider(repo, first, []string{"./landscape/database.yaml"}, SecretsProviderMock{}, chartLoadMock, "pfx-", "spa", "", "")
+	cs, err = gs.Components()
+	require.NoError(t, err)
+	require.Len(t, cs, 1)
+
+	// a new commit alone doesn't make a component differ
+	cur := cs["pfx-database"]
+	des := *cur
+	des.Configuration = Configuration{}
+	for k, v := range cur.Configuration {
+		des.Configuration[k] = v
+	}
+	m.Commit = second
+	des.Configuration.SetMetadata(m)
+	require.True(t, cur.Equals(&des))
+
+	gs = NewGitStateProvider(repo, "no-such-branch", nil, SecretsProviderMock{}, chartLoadMock, "pfx-", "spa", "", "")
+	_, err = gs.Components()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to resolve git ref `no-such-branch`")
+
+	gs = NewGitStateProvider(repo, "HEAD", []string{"elsewhere"}, SecretsProviderMock{}, chartLoadMock, "pfx-", "spa", "", "")
+	_, err = gs.Components()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "`elsewhere` not found at commit")
+}
@@ -116,6 +116,44 @@ ref: %s
 	require.Equal(t, "", c0.Release.Version)
 }
 
+func TestFileStateProviderDependencies(t *testing.T) {
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		t.Logf("MockChartLoader %#v", chartRef)
+		c := &chart.Chart{
+			Metadata: &chart.Metadata{
+				Name:    "chart-name",
+				Version: "1.3.37",
+			},
+			Values: &chart.Config{Raw: "message: xxx\n"},
+		}
+
+		return c, "", nil
+	})
+
+	fs := NewFileStateProvider([]string{"../../test/landscapes/dependencies/"}, SecretsProviderMock{}, chartLoadMock, "pfx-", "spa", "", "")
+	cs, err := fs.Components()
+	require.NoError(t, err)
+	require.Len(t, cs, 2)
+	require.Nil(t, cs["pfx-database"].DependsOn)
+	require.Equal(t, []string{"pfx-database"}, cs["pfx-service"].DependsOn)
+
+	// the dependencies survive the round trip through the release values
+	svc := cs["pfx-service"]
+	fromRelease := NewComponent(svc.Name, svc.Namespace, svc.Release, svc.Configuration, Configurations{}, SecretNames{})
+	require.Equal(t, []string{"pfx-database"}, fromRelease.DependsOn)
+
+	// a dependency on a component that isn't there
+	fs = NewFileStateProvider([]string{"../../test/landscapes/dependencies/service.yaml"}, SecretsProviderMock{}, chartLoadMock, "pfx-", "spa", "", "")
+	_, err = fs.Components()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown component `pfx-database`")
+
+	fs = NewFileStateProvider([]string{"../../test/landscapes/dependency-cycle/"}, SecretsProviderMock{}, chartLoadMock, "pfx-", "spa", "", "")
+	_, err = fs.Components()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "dependency cycle")
+}
+
 func TestHelmStateProviderComponents(t *testing.T) {
 	helmMock := &HelmclientMock{
 		listReleases: func(opts ...helm.ReleaseListOption) (*services.ListReleasesResponse, error) {
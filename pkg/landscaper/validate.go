@@ -0,0 +1,148 @@
+package landscaper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+)
+
+var (
+	yamlErrorLineRegexp = regexp.MustCompile(`line (\d+)`)
+	nameKeyRegexp       = regexp.MustCompile(`(?m)^name[ \t]*:`)
+	chartKeyRegexp      = regexp.MustCompile(`(?m)^[ \t]+chart[ \t]*:`)
+	configKeyRegexp     = regexp.MustCompile(`(?m)^configuration[ \t]*:`)
+	dependsOnKeyRegexp  = regexp.MustCompile(`(?m)^dependsOn[ \t]*:`)
+)
+
+// ValidationError describes a problem found in a component file
+type ValidationError struct {
+	File string
+	Line int // 0 if unknown
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	if e.File == "" {
+		return e.Err.Error()
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Err)
+}
+
+// FileValidator checks component files without contacting Tiller or Kubernetes
+type FileValidator interface {
+	Validate() []*ValidationError
+}
+
+// NewFileValidator creates a FileValidator for the given files; charts are loaded with chartLoader
+func NewFileValidator(fileNames []string, chartLoader ChartLoader, releaseNamePrefix, namespace string, environment string, configurationOverrideFile string) FileValidator {
+	return &fileStateProvider{
+		fileNames:                 fileNames,
+		chartLoader:               chartLoader,
+		releaseNamePrefix:         releaseNamePrefix,
+		namespace:                 namespace,
+		environment:               environment,
+		configurationOverrideFile: configurationOverrideFile,
+	}
+}
+
+// Validate parses and normalizes every component file, checks the final release names, resolves the charts and
+// coalesces the configuration with the chart defaults, and checks the dependencies between the components. Secrets are not read.
+// It reports all problems instead of the first one.
+func (cp *fileStateProvider) Validate() []*ValidationError {
+	errs := []*ValidationError{}
+
+	files, err := expandComponentFiles(cp.fileNames)
+	if err != nil {
+		return append(errs, &ValidationError{Err: err})
+	}
+
+	names := map[string]string{}
+	contents := map[string][]byte{}
+	components := Components{}
+	for _, filename := range files {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			errs = append(errs, &ValidationError{File: filename, Err: err})
+			continue
+		}
+
+		cmp, err := newComponentFromYAML(content)
+		if err != nil {
+			errs = append(errs, &ValidationError{File: filename, Line: yamlErrorLine(err), Err: err})
+			continue
+		}
+
+		if err := cp.normalizeFromFile(cmp); err != nil {
+			errs = append(errs, &ValidationError{File: filename, Line: keyLine(content, chartKeyRegexp), Err: err})
+			continue
+		}
+
+		if err := cmp.Validate(); err != nil {
+			errs = append(errs, &ValidationError{File: filename, Line: keyLine(content, nameKeyRegexp), Err: err})
+		}
+
+		if other, ok := names[cmp.Name]; ok {
+			errs = append(errs, &ValidationError{File: filename, Line: keyLine(content, nameKeyRegexp), Err: fmt.Errorf("duplicate component name `%s`, also in `%s`", cmp.Name, other)})
+		}
+		names[cmp.Name] = filename
+		contents[filename] = content
+		components[cmp.Name] = cmp
+
+		chartRef, err := cmp.FullChartRef()
+		if err != nil {
+			errs = append(errs, &ValidationError{File: filename, Line: keyLine(content, chartKeyRegexp), Err: err})
+			continue
+		}
+		if _, _, err := cp.chartLoader.Load(chartRef); err != nil {
+			errs = append(errs, &ValidationError{File: filename, Line: keyLine(content, chartKeyRegexp), Err: err})
+			continue
+		}
+
+		if err := cp.coalesceComponent(cmp); err != nil {
+			errs = append(errs, &ValidationError{File: filename, Line: keyLine(content, configKeyRegexp), Err: err})
+		}
+	}
+
+	for _, name := range components.names() {
+		for _, dep := range components[name].DependsOn {
+			if _, ok := components[dep]; !ok {
+				filename := names[name]
+				errs = append(errs, &ValidationError{File: filename, Line: keyLine(contents[filename], dependsOnKeyRegexp), Err: fmt.Errorf("component `%s` depends on unknown component `%s`", name, dep)})
+			}
+		}
+	}
+	if _, err := components.dependencyOrder(); err != nil {
+		errs = append(errs, &ValidationError{Err: err})
+	}
+
+	return errs
+}
+
+// yamlErrorLine extracts the line number from a YAML parse error, or 0 if there is none
+func yamlErrorLine(err error) int {
+	m := yamlErrorLineRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(m[1])
+	return line
+}
+
+// keyLine returns the line number of the first match of key in content, or 0 if there is none
+func keyLine(content []byte, key *regexp.Regexp) int {
+	loc := key.FindIndex(content)
+	if loc == nil {
+		return 0
+	}
+	line := 1
+	for _, b := range content[:loc[0]] {
+		if b == '\n' {
+			line++
+		}
+	}
+	return line
+}
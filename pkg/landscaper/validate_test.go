@@ -0,0 +1,49 @@
+package landscaper
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func TestFileValidatorReportsAllErrors(t *testing.T) {
+	chartLoadMock := MockChartLoader(func(chartRef string) (*chart.Chart, string, error) {
+		t.Logf("MockChartLoader %#v", chartRef)
+		c := &chart.Chart{
+			Metadata: &chart.Metadata{
+				Name:    "chart-name",
+				Version: "1.3.37",
+			},
+			Values: &chart.Config{Raw: fmt.Sprintf(`
+message: xxx
+ref: %s
+`, chartRef)},
+		}
+
+		return c, "", nil
+	})
+
+	rigsDir := "../../test/landscapes/invalid/"
+	errs := NewFileValidator([]string{rigsDir}, chartLoadMock, "pfx-", "spa", "", "").Validate()
+	require.Len(t, errs, 3)
+
+	byFile := map[string]*ValidationError{}
+	for _, err := range errs {
+		byFile[err.File] = err
+	}
+
+	require.Contains(t, byFile, rigsDir+"broken-yaml.yaml")
+	require.Equal(t, 6, byFile[rigsDir+"broken-yaml.yaml"].Line)
+
+	require.Contains(t, byFile, rigsDir+"long-name.yaml")
+	require.Equal(t, 2, byFile[rigsDir+"long-name.yaml"].Line)
+	require.Contains(t, byFile[rigsDir+"long-name.yaml"].Error(), "long-name.yaml:2: ")
+
+	require.Contains(t, byFile, rigsDir+"unprefixed-chart.yaml")
+	require.Equal(t, 3, byFile[rigsDir+"unprefixed-chart.yaml"].Line)
+
+	errs = NewFileValidator([]string{rigsDir + "hello-world.yaml"}, chartLoadMock, "pfx-", "spa", "", "").Validate()
+	require.Len(t, errs, 0)
+}